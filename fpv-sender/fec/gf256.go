@@ -0,0 +1,71 @@
+package fec
+
+// GF(2^8) arithmetic using the AES reduction polynomial
+// x^8+x^4+x^3+x+1 (0x11b) and generator 3, built into log/antilog tables
+// at init so multiply/divide are table lookups instead of per-call
+// polynomial reduction.
+var (
+	expTable [512]byte
+	logTable [256]byte
+)
+
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		expTable[i] = x
+		logTable[x] = byte(i)
+		x = mulNoTable(x, 3)
+	}
+	for i := 255; i < 512; i++ {
+		expTable[i] = expTable[i-255]
+	}
+}
+
+// mulNoTable multiplies two GF(2^8) elements by hand (carry-less multiply
+// with reduction), used only to bootstrap the log/exp tables above.
+func mulNoTable(a, b byte) byte {
+	var p byte
+	for b > 0 {
+		if b&1 != 0 {
+			p ^= a
+		}
+		hi := a & 0x80
+		a <<= 1
+		if hi != 0 {
+			a ^= 0x1b
+		}
+		b >>= 1
+	}
+	return p
+}
+
+// gfAdd is GF(2^8) addition (and subtraction, which is identical in
+// characteristic 2).
+func gfAdd(a, b byte) byte {
+	return a ^ b
+}
+
+// gfMul is GF(2^8) multiplication.
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+int(logTable[b])]
+}
+
+// gfDiv is GF(2^8) division; b must be non-zero.
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	diff := int(logTable[a]) - int(logTable[b])
+	if diff < 0 {
+		diff += 255
+	}
+	return expTable[diff]
+}
+
+// gfInverse returns the multiplicative inverse of a non-zero GF(2^8) element.
+func gfInverse(a byte) byte {
+	return expTable[255-int(logTable[a])]
+}
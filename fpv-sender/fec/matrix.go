@@ -0,0 +1,121 @@
+package fec
+
+import "fmt"
+
+// matrix is a dense rows x cols matrix of GF(2^8) elements, row-major.
+type matrix [][]byte
+
+func newMatrix(rows, cols int) matrix {
+	m := make(matrix, rows)
+	for i := range m {
+		m[i] = make([]byte, cols)
+	}
+	return m
+}
+
+func identityMatrix(n int) matrix {
+	m := newMatrix(n, n)
+	for i := 0; i < n; i++ {
+		m[i][i] = 1
+	}
+	return m
+}
+
+// cauchyMatrix builds a (rows x cols) Cauchy matrix: entry[i][j] =
+// 1/(x_i + y_j) for x_i = i and y_j = rows+j. Choosing the y's strictly
+// above the x's range keeps every x_i distinct from every y_j, which is
+// exactly what makes every square submatrix of a Cauchy matrix invertible
+// over GF(2^8) -- the property an erasure code needs: any k of the k+m
+// encoded shards must be enough to recover the k data shards.
+func cauchyMatrix(rows, cols int) matrix {
+	m := newMatrix(rows, cols)
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
+			m[i][j] = gfInverse(gfAdd(byte(i), byte(rows+j)))
+		}
+	}
+	return m
+}
+
+func (m matrix) rows() int { return len(m) }
+func (m matrix) cols() int {
+	if len(m) == 0 {
+		return 0
+	}
+	return len(m[0])
+}
+
+// multiply returns m x other.
+func (m matrix) multiply(other matrix) matrix {
+	out := newMatrix(m.rows(), other.cols())
+	for r := 0; r < m.rows(); r++ {
+		for c := 0; c < other.cols(); c++ {
+			var sum byte
+			for k := 0; k < m.cols(); k++ {
+				sum = gfAdd(sum, gfMul(m[r][k], other[k][c]))
+			}
+			out[r][c] = sum
+		}
+	}
+	return out
+}
+
+// subMatrix returns a new matrix containing only the given rows of m.
+func (m matrix) subMatrix(rowIndexes []int) matrix {
+	out := newMatrix(len(rowIndexes), m.cols())
+	for i, r := range rowIndexes {
+		copy(out[i], m[r])
+	}
+	return out
+}
+
+// invert returns the inverse of a square matrix via Gauss-Jordan
+// elimination over GF(2^8), augmenting m with the identity and reducing m
+// to the identity in lockstep.
+func (m matrix) invert() (matrix, error) {
+	n := m.rows()
+	if n != m.cols() {
+		return nil, fmt.Errorf("fec: cannot invert non-square %dx%d matrix", n, m.cols())
+	}
+
+	work := newMatrix(n, 2*n)
+	for r := 0; r < n; r++ {
+		copy(work[r], m[r])
+		work[r][n+r] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := -1
+		for r := col; r < n; r++ {
+			if work[r][col] != 0 {
+				pivot = r
+				break
+			}
+		}
+		if pivot == -1 {
+			return nil, fmt.Errorf("fec: matrix is singular")
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+
+		inv := gfInverse(work[col][col])
+		for c := 0; c < 2*n; c++ {
+			work[col][c] = gfMul(work[col][c], inv)
+		}
+
+		for r := 0; r < n; r++ {
+			if r == col || work[r][col] == 0 {
+				continue
+			}
+			factor := work[r][col]
+			for c := 0; c < 2*n; c++ {
+				work[r][c] = gfAdd(work[r][c], gfMul(factor, work[col][c]))
+			}
+		}
+	}
+
+	out := newMatrix(n, n)
+	for r := 0; r < n; r++ {
+		copy(out[r], work[r][n:])
+	}
+	return out, nil
+}
@@ -0,0 +1,173 @@
+// Package fec implements a systematic Reed-Solomon erasure code over
+// GF(2^8), the same scheme kcp-go uses for its FEC layer, adapted here to
+// protect a fragmented Access Unit: split it into k equal (zero-padded)
+// shards, compute m parity shards, and recover up to m missing shards of
+// the k+m from any k that arrive.
+package fec
+
+import "fmt"
+
+// MaxTotalShards bounds k+m so shard indexes fit in a byte on the wire.
+const MaxTotalShards = 256
+
+// Codec encodes and reconstructs k-data/m-parity shard groups for a fixed
+// (k, m).
+type Codec struct {
+	k, m int
+
+	// encodeMatrix is (k+m) x k with its top k rows forming the identity, so
+	// encoding data shards is a no-op (pass-through) and only the bottom m
+	// rows need the GF(2^8) multiply-accumulate.
+	encodeMatrix matrix
+}
+
+// New creates a Codec for k data shards and m parity shards. Both must be
+// at least 1 and k+m must fit in MaxTotalShards. The Cauchy construction
+// also needs 2k+m distinct field elements, so 2k+m must fit in
+// MaxTotalShards as well.
+func New(k, m int) (*Codec, error) {
+	if k <= 0 || m <= 0 {
+		return nil, fmt.Errorf("fec: k and m must be positive, got k=%d m=%d", k, m)
+	}
+	if k+m > MaxTotalShards {
+		return nil, fmt.Errorf("fec: k+m=%d exceeds MaxTotalShards=%d", k+m, MaxTotalShards)
+	}
+	if 2*k+m > MaxTotalShards {
+		return nil, fmt.Errorf("fec: 2k+m=%d exceeds the %d field elements the Cauchy matrix can use", 2*k+m, MaxTotalShards)
+	}
+
+	cauchy := cauchyMatrix(k+m, k)
+	top := cauchy.subMatrix(indexRange(k))
+	topInv, err := top.invert()
+	if err != nil {
+		return nil, fmt.Errorf("fec: building encode matrix: %w", err)
+	}
+
+	return &Codec{k: k, m: m, encodeMatrix: cauchy.multiply(topInv)}, nil
+}
+
+func indexRange(n int) []int {
+	idx := make([]int, n)
+	for i := range idx {
+		idx[i] = i
+	}
+	return idx
+}
+
+// K returns the configured data shard count.
+func (c *Codec) K() int { return c.k }
+
+// M returns the configured parity shard count.
+func (c *Codec) M() int { return c.m }
+
+// Encode fills in the m parity shards from the k data shards. shards must
+// have length k+m; shards[0:k] are the data (already populated, all the
+// same length) and shards[k:k+m] must be preallocated to that same length
+// -- Encode only writes into them, it never touches the data shards.
+func (c *Codec) Encode(shards [][]byte) error {
+	if len(shards) != c.k+c.m {
+		return fmt.Errorf("fec: Encode needs %d shards, got %d", c.k+c.m, len(shards))
+	}
+	shardLen := len(shards[0])
+	for i, s := range shards {
+		if len(s) != shardLen {
+			return fmt.Errorf("fec: shard %d has length %d, want %d", i, len(s), shardLen)
+		}
+	}
+
+	for p := 0; p < c.m; p++ {
+		row := c.encodeMatrix[c.k+p]
+		out := shards[c.k+p]
+		for b := 0; b < shardLen; b++ {
+			var sum byte
+			for j := 0; j < c.k; j++ {
+				sum = gfAdd(sum, gfMul(row[j], shards[j][b]))
+			}
+			out[b] = sum
+		}
+	}
+	return nil
+}
+
+// Reconstruct fills in missing shards given present, a parallel slice
+// reporting which of shards[0:k+m] actually arrived. It needs at least k
+// of the k+m to succeed. Only the data shards (index < k) are solved for
+// directly; any still-missing parity shards are then re-derived from the
+// now-complete data with the same encode-matrix rows Encode uses, so on
+// return every shard in shards is
+// populated (up to len(shards), which may be less than k+m if the caller
+// only needs the data back).
+func (c *Codec) Reconstruct(shards [][]byte, present []bool) error {
+	if len(present) != c.k+c.m {
+		return fmt.Errorf("fec: Reconstruct needs a %d-entry present slice, got %d", c.k+c.m, len(present))
+	}
+
+	var haveIdx []int
+	shardLen := 0
+	for i, ok := range present {
+		if ok {
+			haveIdx = append(haveIdx, i)
+			if shardLen == 0 {
+				shardLen = len(shards[i])
+			}
+		}
+	}
+	if len(haveIdx) < c.k {
+		return fmt.Errorf("fec: need at least %d of %d shards, have %d", c.k, c.k+c.m, len(haveIdx))
+	}
+
+	missingData := false
+	for i := 0; i < c.k; i++ {
+		if !present[i] {
+			missingData = true
+			break
+		}
+	}
+
+	if missingData {
+		use := haveIdx[:c.k]
+		sub := c.encodeMatrix.subMatrix(use)
+		subInv, err := sub.invert()
+		if err != nil {
+			return fmt.Errorf("fec: reconstructing: %w", err)
+		}
+
+		for i := 0; i < c.k; i++ {
+			if present[i] {
+				continue
+			}
+			if shards[i] == nil || len(shards[i]) != shardLen {
+				shards[i] = make([]byte, shardLen)
+			}
+			row := subInv[i]
+			for b := 0; b < shardLen; b++ {
+				var sum byte
+				for j, idx := range use {
+					sum = gfAdd(sum, gfMul(row[j], shards[idx][b]))
+				}
+				shards[i][b] = sum
+			}
+			present[i] = true
+		}
+	}
+
+	for i := c.k; i < len(shards) && i < c.k+c.m; i++ {
+		if present[i] {
+			continue
+		}
+		if shards[i] == nil || len(shards[i]) != shardLen {
+			shards[i] = make([]byte, shardLen)
+		}
+		row := c.encodeMatrix[i]
+		for b := 0; b < shardLen; b++ {
+			var sum byte
+			for j := 0; j < c.k; j++ {
+				sum = gfAdd(sum, gfMul(row[j], shards[j][b]))
+			}
+			shards[i][b] = sum
+		}
+		present[i] = true
+	}
+
+	return nil
+}
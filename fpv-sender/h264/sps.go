@@ -0,0 +1,309 @@
+package h264
+
+import "errors"
+
+// Errors returned by ParseSPS.
+var (
+	ErrNotSPS    = errors.New("NAL is not a sequence parameter set")
+	ErrTruncated = errors.New("SPS RBSP truncated")
+)
+
+// SPS holds the fields of a sequence parameter set that are useful for
+// populating the protocol.Hello capabilities exchange.
+type SPS struct {
+	ProfileIDC        uint8
+	ConstraintFlags   uint8 // constraint_set0_flag..constraint_set5_flag packed as bits 7..2
+	LevelIDC          uint8
+	SeqParameterSetID uint32
+
+	ChromaFormatIDC uint32 // only set for high-family profiles, defaults to 1 (4:2:0) otherwise
+
+	Log2MaxFrameNum uint32
+	PicOrderCntType uint32
+
+	MaxNumRefFrames               uint32
+	GapsInFrameNumValueAllowed    bool
+	PicWidthInMbsMinus1           uint32
+	PicHeightInMapUnitsMinus1     uint32
+	FrameMbsOnlyFlag              bool
+
+	FrameCropLeft   uint32
+	FrameCropRight  uint32
+	FrameCropTop    uint32
+	FrameCropBottom uint32
+
+	// Width/Height are the coded picture dimensions in pixels, after
+	// accounting for frame_mbs_only_flag and frame cropping.
+	Width  uint16
+	Height uint16
+
+	// FPS is derived from VUI timing_info, 0 if not present.
+	FPS float64
+}
+
+// high-family profiles carry an extra chroma_format_idc/bit_depth block (Annex A, 7.3.2.1.1)
+var highProfiles = map[uint8]bool{
+	100: true, 110: true, 122: true, 244: true, 44: true,
+	83: true, 86: true, 118: true, 128: true, 138: true, 139: true, 134: true, 135: true,
+}
+
+// ParseSPS decodes a sequence parameter set NAL unit.
+func ParseSPS(nal *NALUnit) (*SPS, error) {
+	if nal.Type != NALTypeSPS {
+		return nil, ErrNotSPS
+	}
+
+	payload := nalPayload(nal.Data)
+	if len(payload) < 1 {
+		return nil, ErrTruncated
+	}
+	// payload[0] is the NAL header byte, decoded fields start after it.
+	rbsp := removeEmulationPrevention(payload[1:])
+
+	r := newBitReader(rbsp)
+	sps := &SPS{ChromaFormatIDC: 1}
+
+	sps.ProfileIDC = uint8(r.readBits(8))
+	sps.ConstraintFlags = uint8(r.readBits(8))
+	sps.LevelIDC = uint8(r.readBits(8))
+	sps.SeqParameterSetID = r.readUE()
+
+	if highProfiles[sps.ProfileIDC] {
+		sps.ChromaFormatIDC = r.readUE()
+		if sps.ChromaFormatIDC == 3 {
+			r.skipBits(1) // separate_colour_plane_flag
+		}
+		r.readUE() // bit_depth_luma_minus8
+		r.readUE() // bit_depth_chroma_minus8
+		r.skipBits(1) // qpprime_y_zero_transform_bypass_flag
+		if r.readBit() == 1 { // seq_scaling_matrix_present_flag
+			n := 8
+			if sps.ChromaFormatIDC == 3 {
+				n = 12
+			}
+			for i := 0; i < n; i++ {
+				if r.readBit() == 1 {
+					size := 16
+					if i >= 6 {
+						size = 64
+					}
+					skipScalingList(r, size)
+				}
+			}
+		}
+	}
+
+	sps.Log2MaxFrameNum = r.readUE() + 4
+	sps.PicOrderCntType = r.readUE()
+	switch sps.PicOrderCntType {
+	case 0:
+		r.readUE() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.skipBits(1) // delta_pic_order_always_zero_flag
+		r.readSE()    // offset_for_non_ref_pic
+		r.readSE()    // offset_for_top_to_bottom_field
+		n := r.readUE()
+		for i := uint32(0); i < n; i++ {
+			r.readSE() // offset_for_ref_frame[i]
+		}
+	}
+
+	sps.MaxNumRefFrames = r.readUE()
+	sps.GapsInFrameNumValueAllowed = r.readBit() == 1
+	sps.PicWidthInMbsMinus1 = r.readUE()
+	sps.PicHeightInMapUnitsMinus1 = r.readUE()
+	sps.FrameMbsOnlyFlag = r.readBit() == 1
+	if !sps.FrameMbsOnlyFlag {
+		r.skipBits(1) // mb_adaptive_frame_field_flag
+	}
+	r.skipBits(1) // direct_8x8_inference_flag
+
+	if r.readBit() == 1 { // frame_cropping_flag
+		sps.FrameCropLeft = r.readUE()
+		sps.FrameCropRight = r.readUE()
+		sps.FrameCropTop = r.readUE()
+		sps.FrameCropBottom = r.readUE()
+	}
+
+	if r.err != nil {
+		return nil, ErrTruncated
+	}
+
+	subWidthC, subHeightC := uint32(2), uint32(2)
+	if sps.ChromaFormatIDC == 3 {
+		subWidthC, subHeightC = 1, 1
+	} else if sps.ChromaFormatIDC == 0 {
+		subWidthC, subHeightC = 1, 1 // monochrome, cropping is in luma samples
+	}
+
+	picWidthInSamples := (sps.PicWidthInMbsMinus1 + 1) * 16
+	frameHeightInMbs := (2 - b2u(sps.FrameMbsOnlyFlag)) * (sps.PicHeightInMapUnitsMinus1 + 1)
+	picHeightInSamples := frameHeightInMbs * 16
+
+	cropUnitX := subWidthC
+	cropUnitY := subHeightC * (2 - b2u(sps.FrameMbsOnlyFlag))
+
+	sps.Width = uint16(picWidthInSamples - (sps.FrameCropLeft+sps.FrameCropRight)*cropUnitX)
+	sps.Height = uint16(picHeightInSamples - (sps.FrameCropTop+sps.FrameCropBottom)*cropUnitY)
+
+	// VUI is optional and any error parsing it should not fail the whole SPS.
+	if r.readBit() == 1 { // vui_parameters_present_flag
+		sps.FPS = parseVUITiming(r)
+	}
+
+	return sps, nil
+}
+
+func b2u(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// parseVUITiming reads just enough of the VUI to extract timing_info and
+// returns the derived frame rate, or 0 if timing_info is absent.
+func parseVUITiming(r *bitReader) float64 {
+	if r.readBit() == 1 { // aspect_ratio_info_present_flag
+		if aspectRatioIDC := r.readBits(8); aspectRatioIDC == 255 { // Extended_SAR
+			r.skipBits(16) // sar_width
+			r.skipBits(16) // sar_height
+		}
+	}
+	if r.readBit() == 1 { // overscan_info_present_flag
+		r.skipBits(1)
+	}
+	if r.readBit() == 1 { // video_signal_type_present_flag
+		r.skipBits(3) // video_format
+		r.skipBits(1) // video_full_range_flag
+		if r.readBit() == 1 { // colour_description_present_flag
+			r.skipBits(8) // colour_primaries
+			r.skipBits(8) // transfer_characteristics
+			r.skipBits(8) // matrix_coefficients
+		}
+	}
+	if r.readBit() == 1 { // chroma_loc_info_present_flag
+		r.readUE()
+		r.readUE()
+	}
+	if r.readBit() == 1 { // timing_info_present_flag
+		numUnitsInTick := r.readBits(32)
+		timeScale := r.readBits(32)
+		if r.err != nil || numUnitsInTick == 0 {
+			return 0
+		}
+		// time_scale / num_units_in_tick gives field rate; divide by 2 for
+		// progressive frame rate per Annex E.2.1.
+		return float64(timeScale) / float64(numUnitsInTick) / 2
+	}
+	return 0
+}
+
+// skipScalingList skips a scaling_list() of the given size without storing it.
+func skipScalingList(r *bitReader, size int) {
+	lastScale, nextScale := 8, 8
+	for j := 0; j < size; j++ {
+		if nextScale != 0 {
+			deltaScale := r.readSE()
+			nextScale = (lastScale + int(deltaScale) + 256) % 256
+		}
+		if nextScale != 0 {
+			lastScale = nextScale
+		}
+	}
+}
+
+// nalPayload strips the Annex B start code, returning header byte + RBSP.
+func nalPayload(data []byte) []byte {
+	if len(data) >= 4 && data[0] == 0 && data[1] == 0 && data[2] == 0 && data[3] == 1 {
+		return data[4:]
+	}
+	if len(data) >= 3 && data[0] == 0 && data[1] == 0 && data[2] == 1 {
+		return data[3:]
+	}
+	return data
+}
+
+// removeEmulationPrevention strips emulation prevention bytes (the third
+// byte of any 0x00 0x00 0x03 sequence) to recover the raw RBSP.
+func removeEmulationPrevention(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	zeros := 0
+	for _, b := range data {
+		if zeros >= 2 && b == 0x03 {
+			zeros = 0
+			continue
+		}
+		if b == 0x00 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// bitReader reads MSB-first bits from a byte slice, with exp-Golomb helpers.
+type bitReader struct {
+	data   []byte
+	bitPos int // absolute bit offset
+	err    error
+}
+
+func newBitReader(data []byte) *bitReader {
+	return &bitReader{data: data}
+}
+
+func (r *bitReader) readBit() uint32 {
+	if r.err != nil {
+		return 0
+	}
+	bytePos := r.bitPos >> 3
+	if bytePos >= len(r.data) {
+		r.err = ErrTruncated
+		return 0
+	}
+	shift := 7 - uint(r.bitPos&7)
+	bit := (r.data[bytePos] >> shift) & 1
+	r.bitPos++
+	return uint32(bit)
+}
+
+func (r *bitReader) skipBits(n int) {
+	for i := 0; i < n; i++ {
+		r.readBit()
+	}
+}
+
+func (r *bitReader) readBits(n int) uint32 {
+	var v uint32
+	for i := 0; i < n; i++ {
+		v = (v << 1) | r.readBit()
+	}
+	return v
+}
+
+// readUE reads an Exp-Golomb unsigned value (ue(v)).
+func (r *bitReader) readUE() uint32 {
+	leadingZeros := 0
+	for r.readBit() == 0 {
+		leadingZeros++
+		if r.err != nil || leadingZeros > 32 {
+			return 0
+		}
+	}
+	if leadingZeros == 0 {
+		return 0
+	}
+	return (1 << uint(leadingZeros)) - 1 + r.readBits(leadingZeros)
+}
+
+// readSE reads an Exp-Golomb signed value (se(v)).
+func (r *bitReader) readSE() int32 {
+	ue := r.readUE()
+	if ue%2 == 0 {
+		return -int32(ue / 2)
+	}
+	return int32(ue+1) / 2
+}
@@ -0,0 +1,345 @@
+// Package h265 provides utilities for reading and parsing H.265/HEVC Annex B
+// streams, parallel to the h264 package.
+package h265
+
+import (
+	"errors"
+	"io"
+)
+
+// NAL unit types (ITU-T H.265 Table 7-1).
+const (
+	NALTypeTrailN    = 0
+	NALTypeTrailR    = 1
+	NALTypeRASLN     = 8
+	NALTypeRASLR     = 9
+	NALTypeBLAWLP    = 16
+	NALTypeBLAWRADL  = 17
+	NALTypeBLANLP    = 18
+	NALTypeIDRWRADL  = 19 // IDR_W_RADL
+	NALTypeIDRNLP    = 20 // IDR_N_LP
+	NALTypeCRA       = 21 // CRA_NUT
+	NALTypeVPS       = 32
+	NALTypeSPS       = 33
+	NALTypePPS       = 34
+	NALTypeAUD       = 35
+	NALTypePrefixSEI = 39
+	NALTypeSuffixSEI = 40
+)
+
+// Errors
+var (
+	ErrNoStartCode = errors.New("no start code found")
+	ErrShortNAL    = errors.New("NAL unit too short")
+)
+
+// NALUnit represents a single HEVC NAL unit.
+type NALUnit struct {
+	Type    uint8  // nal_unit_type (6 bits)
+	LayerID uint8  // nuh_layer_id (6 bits)
+	TID     uint8  // temporal_id = nuh_temporal_id_plus1 - 1
+	Data    []byte // Full NAL including start code + 2-byte header
+}
+
+// isIRAP returns true if nalType is an Intra Random Access Point picture
+// (BLA, IDR or CRA, types 16-23).
+func isIRAP(nalType uint8) bool {
+	return nalType >= NALTypeBLAWLP && nalType <= 23
+}
+
+// IsKeyframe returns true if this NAL starts an IRAP picture (BLA/IDR/CRA).
+func (n *NALUnit) IsKeyframe() bool {
+	return isIRAP(n.Type)
+}
+
+// IsVPS returns true if this is a video parameter set.
+func (n *NALUnit) IsVPS() bool {
+	return n.Type == NALTypeVPS
+}
+
+// IsSPS returns true if this is a sequence parameter set.
+func (n *NALUnit) IsSPS() bool {
+	return n.Type == NALTypeSPS
+}
+
+// IsPPS returns true if this is a picture parameter set.
+func (n *NALUnit) IsPPS() bool {
+	return n.Type == NALTypePPS
+}
+
+// isSlice returns true if nalType is a VCL (slice) NAL, i.e. types 0-31.
+func isSlice(nalType uint8) bool {
+	return nalType <= 31
+}
+
+// isFirstSliceInFrame checks if a slice NAL is the first slice segment of a
+// new picture, by parsing first_slice_segment_in_pic_flag - the very first
+// bit of the slice segment header, immediately after the 2-byte NAL header
+// and before any header extension.
+func isFirstSliceInFrame(nalData []byte) bool {
+	payload := nalPayload(nalData)
+	if len(payload) < 3 {
+		return true // Can't parse, assume new frame
+	}
+	// payload[0:2] is the NAL header, the slice segment header starts at payload[2]
+	return payload[2]&0x80 != 0
+}
+
+// nalPayload strips the Annex B start code, returning the 2-byte NAL header
+// followed by the RBSP.
+func nalPayload(data []byte) []byte {
+	if len(data) >= 4 && data[0] == 0 && data[1] == 0 && data[2] == 0 && data[3] == 1 {
+		return data[4:]
+	}
+	if len(data) >= 3 && data[0] == 0 && data[1] == 0 && data[2] == 1 {
+		return data[3:]
+	}
+	return data
+}
+
+// AccessUnit represents a complete HEVC frame (one or more NAL units).
+type AccessUnit struct {
+	NALs       []NALUnit
+	IsKeyframe bool   // True if contains an IRAP (BLA/IDR/CRA) slice
+	HasSPSPPS  bool   // True if contains a VPS, SPS or PPS
+	Data       []byte // Complete AU data (Annex B format with start codes)
+}
+
+// Reader reads H.265 Annex B NAL units from a stream.
+type Reader struct {
+	r       io.Reader
+	buf     []byte
+	pos     int
+	end     int
+	auBuf   []byte
+	pending *NALUnit
+}
+
+// NewReader creates a new H.265 Annex B reader.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		r:     r,
+		buf:   make([]byte, 256*1024),
+		auBuf: make([]byte, 0, 128*1024),
+	}
+}
+
+// ReadAccessUnit reads the next complete Access Unit, delimited by an AUD
+// NAL or by the first slice segment of a new picture.
+func (r *Reader) ReadAccessUnit() (*AccessUnit, error) {
+	au := &AccessUnit{
+		NALs: make([]NALUnit, 0, 8),
+	}
+	r.auBuf = r.auBuf[:0]
+
+	sawSlice := false
+
+	if r.pending != nil {
+		nal := *r.pending
+		r.pending = nil
+
+		r.auBuf = append(r.auBuf, nal.Data...)
+		au.NALs = append(au.NALs, nal)
+
+		if nal.IsKeyframe() {
+			au.IsKeyframe = true
+		}
+		if nal.IsVPS() || nal.IsSPS() || nal.IsPPS() {
+			au.HasSPSPPS = true
+		}
+		if isSlice(nal.Type) {
+			sawSlice = true
+		}
+	}
+
+	for {
+		nal, err := r.readNAL()
+		if err != nil {
+			if err == io.EOF && len(au.NALs) > 0 {
+				au.Data = make([]byte, len(r.auBuf))
+				copy(au.Data, r.auBuf)
+				return au, nil
+			}
+			return nil, err
+		}
+
+		nalIsSlice := isSlice(nal.Type)
+
+		if nal.Type == NALTypeAUD {
+			if len(au.NALs) > 0 {
+				r.pending = &nal
+				au.Data = make([]byte, len(r.auBuf))
+				copy(au.Data, r.auBuf)
+				return au, nil
+			}
+		} else if sawSlice && nalIsSlice && isFirstSliceInFrame(nal.Data) {
+			r.pending = &nal
+			au.Data = make([]byte, len(r.auBuf))
+			copy(au.Data, r.auBuf)
+			return au, nil
+		}
+
+		r.auBuf = append(r.auBuf, nal.Data...)
+		au.NALs = append(au.NALs, nal)
+
+		if nal.IsKeyframe() {
+			au.IsKeyframe = true
+		}
+		if nal.IsVPS() || nal.IsSPS() || nal.IsPPS() {
+			au.HasSPSPPS = true
+		}
+		if nalIsSlice {
+			sawSlice = true
+		}
+	}
+}
+
+// readNAL reads the next NAL unit.
+func (r *Reader) readNAL() (NALUnit, error) {
+	if r.pos >= r.end {
+		if err := r.fill(); err != nil {
+			return NALUnit{}, err
+		}
+	}
+
+	scPos, scLen, err := r.scanStartCode(r.pos)
+	if err != nil {
+		return NALUnit{}, err
+	}
+
+	r.pos = scPos
+
+	nalData := make([]byte, 0, 64*1024)
+	nalData = append(nalData, r.buf[r.pos:r.pos+scLen]...)
+	r.pos += scLen
+
+	// Need the 2-byte NAL header.
+	for r.pos+2 > r.end {
+		if err := r.fill(); err != nil {
+			return NALUnit{}, err
+		}
+	}
+	header0 := r.buf[r.pos]
+	header1 := r.buf[r.pos+1]
+
+	for {
+		foundEnd := false
+		endPos := r.pos
+
+		for endPos+3 <= r.end {
+			if r.buf[endPos] == 0x00 && r.buf[endPos+1] == 0x00 {
+				if r.buf[endPos+2] == 0x01 {
+					foundEnd = true
+					break
+				}
+				if endPos+3 < r.end && r.buf[endPos+2] == 0x00 && r.buf[endPos+3] == 0x01 {
+					foundEnd = true
+					break
+				}
+			}
+			endPos++
+		}
+
+		if foundEnd {
+			nalData = append(nalData, r.buf[r.pos:endPos]...)
+			r.pos = endPos
+			break
+		}
+
+		keepBytes := 3
+		if r.end-r.pos > keepBytes {
+			nalData = append(nalData, r.buf[r.pos:r.end-keepBytes]...)
+			r.pos = r.end - keepBytes
+		}
+
+		if r.pos > 0 {
+			copy(r.buf, r.buf[r.pos:r.end])
+			r.end -= r.pos
+			r.pos = 0
+		}
+
+		n, err := r.r.Read(r.buf[r.end:])
+		if n > 0 {
+			r.end += n
+		}
+		if err != nil {
+			if err == io.EOF {
+				nalData = append(nalData, r.buf[r.pos:r.end]...)
+				r.pos = r.end
+				break
+			}
+			return NALUnit{}, err
+		}
+	}
+
+	if len(nalData) <= 5 {
+		return r.readNAL()
+	}
+
+	nal := NALUnit{
+		Type:    (header0 >> 1) & 0x3F,
+		LayerID: ((header0 & 0x01) << 5) | (header1 >> 3),
+		TID:     (header1 & 0x07) - 1,
+		Data:    nalData,
+	}
+
+	return nal, nil
+}
+
+// scanStartCode finds the next start code starting from pos.
+func (r *Reader) scanStartCode(from int) (int, int, error) {
+	pos := from
+
+	for {
+		for pos+4 > r.end {
+			if pos > 0 {
+				copy(r.buf, r.buf[pos:r.end])
+				r.end -= pos
+				r.pos -= pos
+				pos = 0
+			}
+
+			n, err := r.r.Read(r.buf[r.end:])
+			if n > 0 {
+				r.end += n
+			}
+			if err != nil {
+				if err == io.EOF && pos < r.end {
+					return r.end, 0, io.EOF
+				}
+				return 0, 0, err
+			}
+		}
+
+		for pos+3 <= r.end {
+			if r.buf[pos] == 0x00 && r.buf[pos+1] == 0x00 {
+				if r.buf[pos+2] == 0x01 {
+					return pos, 3, nil
+				}
+				if pos+3 < r.end && r.buf[pos+2] == 0x00 && r.buf[pos+3] == 0x01 {
+					return pos, 4, nil
+				}
+			}
+			pos++
+		}
+	}
+}
+
+// fill reads more data into the buffer, compacting first if needed.
+func (r *Reader) fill() error {
+	if r.pos > 0 {
+		copy(r.buf, r.buf[r.pos:r.end])
+		r.end -= r.pos
+		r.pos = 0
+	}
+
+	n, err := r.r.Read(r.buf[r.end:])
+	r.end += n
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return io.EOF
+	}
+	return nil
+}
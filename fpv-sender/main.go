@@ -19,6 +19,7 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/binary"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -27,15 +28,20 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
 	"fpv-sender/h264"
+	"fpv-sender/h265"
 	"fpv-sender/protocol"
 	"fpv-sender/sender"
+	"fpv-sender/sender/cc"
+	"fpv-sender/sender/reliable"
 	"fpv-sender/stun"
+	"fpv-sender/transport"
 )
 
 // Configuration from FPV_PLAN.md
@@ -53,15 +59,23 @@ const (
 	Gain      = 4     // Fixed gain
 
 	// Timing constants
-	ProbeIntervalMS      = 20   // 50 Hz probe rate
-	PunchWindowMS        = 3000 // 3s window for hole punching
-	KeepaliveIntervalMS  = 1000 // 1s keepalive
-	SessionIdleTimeoutMS = 3000 // 3s timeout
+	ProbeIntervalMS              = 20   // 50 Hz probe rate
+	PunchWindowMS                = 3000 // 3s window for hole punching
+	KeepaliveIntervalMS          = 1000 // 1s keepalive
+	SessionIdleTimeoutMS         = 3000 // 3s timeout
+	ReliableRetransmitIntervalMS = 100  // sender/reliable ARQ retry tick
 
 	// Socket settings
 	SocketSendBufSize = 256 * 1024 // 256 KB
 )
 
+// errRestartCapture is returned by streamVideoH264/streamVideoHEVC to tell
+// streamVideo to relaunch rpicam-vid at a.currentBitrateBps instead of
+// ending the stream -- how the congestion controller's target bitrate
+// (see cc.Controller.ShouldRestartCapture) actually reaches the encoder,
+// there being no runtime bitrate control socket wired up yet.
+var errRestartCapture = errors.New("sender: restart capture at new bitrate")
+
 // State machine states
 type State int
 
@@ -90,12 +104,25 @@ type App struct {
 	signalURL   string
 	localTarget string // For local testing: direct IP:port
 	localPort   int
+	wireFormat  sender.WireFormat
+	codec       uint8         // protocol.CodecH264 or protocol.CodecH265
+	pathPolicy  sender.Policy // how MultiPath schedules across pathNames, ignored otherwise
 
 	// Network
-	conn       *net.UDPConn
-	localAddr  *net.UDPAddr
-	publicAddr *net.UDPAddr
-	peerAddr   *net.UDPAddr
+	bind         *transport.UDPBind // nil when pathNames is set; see netBind
+	boundPort    int
+	publicAddr4  *net.UDPAddr
+	publicAddr6  *net.UDPAddr
+	peerEndpoint transport.Endpoint
+
+	// Multipath bonding: when pathNames is non-empty, netBind is a
+	// *sender.MultiPath wrapping one device-bound UDPBind per interface
+	// instead of the single a.bind above.
+	pathNames      []string
+	paths          []*sender.Path
+	multiPath      *sender.MultiPath
+	netBind        transport.Bind
+	pathCandidates []*stun.Result // per-path STUN results, populated instead of publicAddr4/6 when len(paths) > 0
 
 	// Session
 	sessionID uint32
@@ -105,12 +132,28 @@ type App struct {
 	// Sender
 	snd *sender.Sender
 
+	// Congestion control: pacer estimates bandwidth/RTT from receiver
+	// reports piggy-backed on inbound Keepalives (see handleKeepaliveReport)
+	// and drives both Packetizer's token-bucket pacing and currentBitrateBps.
+	pacer             *cc.Controller
+	lastCCReportTime  time.Time
+	lastCCFragSent    uint64
+	currentBitrateBps uint32
+
+	// Reliable control channel: IDR requests, encoder-parameter changes,
+	// and telemetry get in-order, retransmitted delivery over the same
+	// socket as video instead of best-effort UDP (see sender/reliable).
+	reliable *reliable.Stream
+
 	// Statistics
 	lastRxTime   time.Time
 	lastRxTsMs   uint32
 	probeSeq     uint32
 	keepaliveSeq uint32
 
+	// Capabilities, auto-populated from the first SPS NAL seen
+	helloSent bool
+
 	// Shutdown
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -122,12 +165,33 @@ func main() {
 	localTarget := flag.String("local", "", "Direct target IP:port for local testing (skip signaling)")
 	localPort := flag.Int("port", 0, "Local UDP port to bind (0 for auto)")
 	signalURL := flag.String("signal", os.Getenv("FPV_SIGNAL_URL"), "Signaling server URL")
+	wireFormat := flag.String("wireformat", "fragment", "Video wire format: fragment (custom) or rtp (RFC 6184)")
+	codec := flag.String("codec", "h264", "Video codec: h264 or h265")
+	paths := flag.String("paths", "", "Comma-separated interfaces to bond (e.g. eth0,wlan0,wwan0); empty disables multipath")
+	pathPolicy := flag.String("path-policy", "weighted", "Multipath scheduling policy when --paths is set: weighted (bonded throughput) or duplicate (send every fragment down every path)")
 	flag.Parse()
 
 	app := &App{
 		signalURL:   *signalURL,
 		localTarget: *localTarget,
 		localPort:   *localPort,
+		wireFormat:  sender.WireFormatFragment,
+		codec:       protocol.CodecH264,
+		pathPolicy:  sender.PolicyWeighted,
+	}
+	for _, name := range strings.Split(*paths, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			app.pathNames = append(app.pathNames, name)
+		}
+	}
+	if *wireFormat == "rtp" {
+		app.wireFormat = sender.WireFormatRTP
+	}
+	if *codec == "h265" {
+		app.codec = protocol.CodecH265
+	}
+	if *pathPolicy == "duplicate" {
+		app.pathPolicy = sender.PolicyDuplicate
 	}
 
 	// Setup context with signal handling
@@ -156,22 +220,32 @@ func (a *App) Run() error {
 
 	log.Printf("Session ID: %08x, Nonce: %016x", a.sessionID, a.nonce)
 
-	// Create UDP socket
-	addr := &net.UDPAddr{IP: net.IPv4zero, Port: a.localPort}
-	conn, err := net.ListenUDP("udp4", addr)
-	if err != nil {
-		return fmt.Errorf("failed to create UDP socket: %w", err)
-	}
-	defer conn.Close()
-	a.conn = conn
-	a.localAddr = conn.LocalAddr().(*net.UDPAddr)
+	a.pacer = cc.NewController()
+	a.currentBitrateBps = Bitrate
 
-	// Set socket buffer size
-	if err := conn.SetWriteBuffer(SocketSendBufSize); err != nil {
-		log.Printf("Warning: failed to set send buffer: %v", err)
-	}
+	if len(a.pathNames) > 0 {
+		if err := a.setupPaths(); err != nil {
+			return fmt.Errorf("failed to set up paths: %w", err)
+		}
+		defer a.multiPath.Close()
+	} else {
+		// Open a dual-stack bind
+		bind, boundPort, err := transport.NewUDPBind(a.localPort)
+		if err != nil {
+			return fmt.Errorf("failed to create UDP socket: %w", err)
+		}
+		defer bind.Close()
+		a.bind = bind
+		a.boundPort = boundPort
+		a.netBind = bind
+
+		// Set socket buffer size
+		if err := bind.SetSendBufferSize(SocketSendBufSize); err != nil {
+			log.Printf("Warning: failed to set send buffer: %v", err)
+		}
 
-	log.Printf("Local address: %s", a.localAddr)
+		log.Printf("Local port: %d", a.boundPort)
+	}
 
 	// Direct local mode (for testing)
 	if a.localTarget != "" {
@@ -182,28 +256,71 @@ func (a *App) Run() error {
 	return a.runP2PMode()
 }
 
+// setupPaths opens one SO_BINDTODEVICE-bound UDPBind per --paths interface
+// and bonds them into a.multiPath, which becomes a.netBind in place of the
+// single a.bind the non-multipath path above uses.
+func (a *App) setupPaths() error {
+	paths := make([]*sender.Path, 0, len(a.pathNames))
+	for i, name := range a.pathNames {
+		bind, boundPort, err := transport.NewUDPBindOnDevice(a.localPort, name)
+		if err != nil {
+			for _, p := range paths {
+				p.Bind.Close()
+			}
+			return fmt.Errorf("path %s: %w", name, err)
+		}
+		if err := bind.SetSendBufferSize(SocketSendBufSize); err != nil {
+			log.Printf("Warning: failed to set send buffer on %s: %v", name, err)
+		}
+		log.Printf("Path %d (%s): local port %d", i, name, boundPort)
+		paths = append(paths, &sender.Path{ID: uint8(i), Name: name, Bind: bind})
+	}
+
+	a.paths = paths
+	a.multiPath = sender.NewMultiPath(paths, a.pathPolicy, a.sessionID, a.wireFormat)
+	a.netBind = a.multiPath
+	return nil
+}
+
 // runLocalMode connects directly to a specified address (for LAN testing)
 func (a *App) runLocalMode() error {
-	peerAddr, err := net.ResolveUDPAddr("udp4", a.localTarget)
+	peerAddr, err := net.ResolveUDPAddr("udp", a.localTarget)
 	if err != nil {
 		return fmt.Errorf("invalid target address: %w", err)
 	}
-	a.peerAddr = peerAddr
+	a.peerEndpoint = transport.Endpoint{IP: peerAddr.IP, Port: peerAddr.Port}
 	a.state = StateConnected
+	for _, p := range a.paths {
+		p.SetEndpoint(a.peerEndpoint)
+	}
 
-	log.Printf("Local mode: sending to %s", a.peerAddr)
+	log.Printf("Local mode: sending to %s", a.peerEndpoint)
 
 	// Create sender
-	a.snd = sender.NewSender(a.conn, a.peerAddr, a.sessionID)
+	cfg := sender.DefaultConfig()
+	cfg.WireFormat = a.wireFormat
+	cfg.Codec = a.codec
+	a.snd = sender.NewSenderWithConfig(a.netBind, a.peerEndpoint, a.sessionID, cfg)
+	a.snd.SetPacer(a.pacer)
+
+	a.reliable = reliable.NewStream(a.netBind, a.peerEndpoint, a.sessionID)
 
-	// Start receiver goroutine (for IDR requests)
+	// Start receiver goroutine(s) (for IDR requests)
 	a.wg.Add(1)
 	go a.receiveLoop()
+	if a.netBind.HasIPv6() {
+		a.wg.Add(1)
+		go a.receiveLoopIPv6()
+	}
 
 	// Start keepalive goroutine
 	a.wg.Add(1)
 	go a.keepaliveLoop()
 
+	// Start the reliable control channel's retransmit ticker
+	a.wg.Add(1)
+	go a.reliableLoop()
+
 	// Start streaming
 	a.state = StateStreaming
 	return a.streamVideo()
@@ -219,14 +336,48 @@ func (a *App) runP2PMode() error {
 	a.state = StateSTUNGather
 	log.Println("Discovering public endpoint via STUN...")
 
-	ctx, cancel := context.WithTimeout(a.ctx, 10*time.Second)
-	result, err := stun.Discover(ctx, a.conn, nil)
-	cancel()
-	if err != nil {
-		return fmt.Errorf("STUN discovery failed: %w", err)
+	if len(a.paths) > 0 {
+		// One discovery per path, run concurrently -- each interface gets
+		// its own server-reflexive candidate instead of a single
+		// publicAddr, since an LTE and a Wi-Fi uplink are NATed
+		// independently, and running them in parallel keeps startup latency
+		// at the slowest single path instead of their sum.
+		a.pathCandidates = make([]*stun.Result, len(a.paths))
+		errs := make([]error, len(a.paths))
+		var wg sync.WaitGroup
+		for i, p := range a.paths {
+			wg.Add(1)
+			go func(i int, p *sender.Path) {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(a.ctx, 10*time.Second)
+				result, err := stun.Discover(ctx, p.Bind, nil)
+				cancel()
+				if err != nil {
+					errs[i] = fmt.Errorf("STUN discovery on %s failed: %w", p.Name, err)
+					return
+				}
+				a.pathCandidates[i] = result
+				log.Printf("Path %d (%s) public address: v4=%v v6=%v (via %s)",
+					i, p.Name, result.PublicAddr4, result.PublicAddr6, result.Server)
+			}(i, p)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		ctx, cancel := context.WithTimeout(a.ctx, 10*time.Second)
+		result, err := stun.Discover(ctx, a.bind, nil)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("STUN discovery failed: %w", err)
+		}
+		a.publicAddr4 = result.PublicAddr4
+		a.publicAddr6 = result.PublicAddr6
+		log.Printf("Public address: v4=%v v6=%v (via %s)", a.publicAddr4, a.publicAddr6, result.Server)
 	}
-	a.publicAddr = result.PublicAddr
-	log.Printf("Public address: %s (via %s)", a.publicAddr, result.Server)
 
 	// Phase 2: Exchange candidates via signaling
 	a.state = StateExchangeCandidates
@@ -236,50 +387,83 @@ func (a *App) runP2PMode() error {
 	return fmt.Errorf("signaling not yet implemented - use --local for testing")
 }
 
-// streamVideo reads from rpicam-vid and sends packets
-func (a *App) streamVideo() error {
+// startCapture launches (or attaches to) the H.264/H.265 Annex B source,
+// shared by both codec's streaming loops. bitrateBps is passed through to
+// rpicam-vid's --bitrate instead of the old fixed Bitrate constant, so
+// streamVideo can relaunch it at cc.Controller's latest target.
+func (a *App) startCapture(bitrateBps uint32) (io.Reader, *exec.Cmd, error) {
 	// Check if we're reading from stdin (pipe mode)
 	stat, _ := os.Stdin.Stat()
 	isPipe := (stat.Mode() & os.ModeCharDevice) == 0
 
-	var input io.Reader
-	var cmd *exec.Cmd
+	rpicamCodec := "h264"
+	if a.codec == protocol.CodecH265 {
+		rpicamCodec = "hevc"
+	}
 
 	if isPipe {
-		log.Println("Reading H.264 from stdin...")
-		input = os.Stdin
-	} else {
-		log.Println("Starting rpicam-vid...")
-		cmd = exec.CommandContext(a.ctx, "rpicam-vid",
-			"-t", "0",
-			"--width", fmt.Sprintf("%d", Width),
-			"--height", fmt.Sprintf("%d", Height),
-			"--framerate", fmt.Sprintf("%d", FPS),
-			"--bitrate", fmt.Sprintf("%d", Bitrate),
-			"--profile", "baseline",
-			"--level", "4.2",
-			"--intra", fmt.Sprintf("%d", IDRPeriod),
-			"--inline",         // Include SPS/PPS with each IDR
-			"--flush",          // Flush output buffers immediately
-			"--denoise", "off", // Disable denoising for speed
-			// CRITICAL: Lock exposure to guarantee consistent FPS
-			"--shutter", fmt.Sprintf("%d", ShutterUS), // Max exposure time
-			"--gain", fmt.Sprintf("%d", Gain), // Fixed gain
-			"--awbgains", "1.5,1.2", // Lock AWB to reduce hunting
-			"--codec", "h264",
-			"-n", // No preview
-			"-o", "-",
-		)
-		stdout, err := cmd.StdoutPipe()
-		if err != nil {
-			return fmt.Errorf("failed to get stdout pipe: %w", err)
+		log.Printf("Reading %s from stdin...", rpicamCodec)
+		return os.Stdin, nil, nil
+	}
+
+	log.Printf("Starting rpicam-vid at %d bps...", bitrateBps)
+	cmd := exec.CommandContext(a.ctx, "rpicam-vid",
+		"-t", "0",
+		"--width", fmt.Sprintf("%d", Width),
+		"--height", fmt.Sprintf("%d", Height),
+		"--framerate", fmt.Sprintf("%d", FPS),
+		"--bitrate", fmt.Sprintf("%d", bitrateBps),
+		"--profile", "baseline",
+		"--level", "4.2",
+		"--intra", fmt.Sprintf("%d", IDRPeriod),
+		"--inline",         // Include SPS/PPS with each IDR
+		"--flush",          // Flush output buffers immediately
+		"--denoise", "off", // Disable denoising for speed
+		// CRITICAL: Lock exposure to guarantee consistent FPS
+		"--shutter", fmt.Sprintf("%d", ShutterUS), // Max exposure time
+		"--gain", fmt.Sprintf("%d", Gain), // Fixed gain
+		"--awbgains", "1.5,1.2", // Lock AWB to reduce hunting
+		"--codec", rpicamCodec,
+		"-n", // No preview
+		"-o", "-",
+	)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get stdout pipe: %w", err)
+	}
+	// Don't forward stderr - rpicam-vid is very chatty
+	if err := cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("failed to start rpicam-vid: %w", err)
+	}
+	return stdout, cmd, nil
+}
+
+// streamVideo reads from rpicam-vid and sends packets. It dispatches to the
+// codec-specific loop selected by --codec, relaunching rpicam-vid at a new
+// bitrate and resuming whenever one of those loops asks to via
+// errRestartCapture instead of treating that as the end of the stream.
+func (a *App) streamVideo() error {
+	for {
+		var err error
+		if a.codec == protocol.CodecH265 {
+			err = a.streamVideoHEVC()
+		} else {
+			err = a.streamVideoH264()
 		}
-		// Don't forward stderr - rpicam-vid is very chatty
-		if err := cmd.Start(); err != nil {
-			return fmt.Errorf("failed to start rpicam-vid: %w", err)
+		if !errors.Is(err, errRestartCapture) {
+			return err
 		}
+	}
+}
+
+// streamVideoH264 reads H.264 from rpicam-vid and sends packets
+func (a *App) streamVideoH264() error {
+	input, cmd, err := a.startCapture(a.currentBitrateBps)
+	if err != nil {
+		return err
+	}
+	if cmd != nil {
 		defer cmd.Wait()
-		input = stdout
 	}
 
 	// Create H.264 reader with small buffer for low latency
@@ -346,6 +530,12 @@ func (a *App) streamVideo() error {
 			longIntervalCount = 0
 		}
 
+		// Auto-populate and send the Hello capabilities announcement from the
+		// first SPS we observe, instead of relying on hand-configured values.
+		if !a.helloSent {
+			a.maybeSendHello(au)
+		}
+
 		// Log IDR frames to verify SPS/PPS are included
 		if au.IsKeyframe {
 			nalTypes := ""
@@ -360,13 +550,153 @@ func (a *App) streamVideo() error {
 			// Per spec: if send fails, drop and continue
 			continue
 		}
+
+		if cmd != nil {
+			if bps, ok := a.pacer.ShouldRestartCapture(a.currentBitrateBps); ok {
+				log.Printf("Congestion controller target bitrate moved to %d bps (was %d), restarting capture", bps, a.currentBitrateBps)
+				a.currentBitrateBps = bps
+				a.helloSent = false
+				cmd.Process.Kill()
+				return errRestartCapture
+			}
+		}
+	}
+}
+
+// streamVideoHEVC reads H.265 from rpicam-vid and sends packets. The HEVC
+// path doesn't yet auto-populate Hello from VPS/SPS (see h264's ParseSPS),
+// so it announces Hello once upfront using the hand-configured values.
+func (a *App) streamVideoHEVC() error {
+	input, cmd, err := a.startCapture(a.currentBitrateBps)
+	if err != nil {
+		return err
+	}
+	if cmd != nil {
+		defer cmd.Wait()
+	}
+
+	reader := h265.NewReader(bufio.NewReaderSize(input, 64*1024))
+
+	hello := protocol.Hello{
+		Width:             Width,
+		Height:            Height,
+		FpsX10:            FPS * 10,
+		BitrateBps:        a.currentBitrateBps,
+		IDRIntervalFrames: IDRPeriod,
+	}
+	if err := a.snd.SendHello(hello); err != nil {
+		log.Printf("Failed to send Hello: %v", err)
+	}
+
+	log.Println("Streaming (H.265)...")
+	frameCount := uint64(0)
+
+	for {
+		select {
+		case <-a.ctx.Done():
+			return nil
+		default:
+		}
+
+		au, err := reader.ReadAccessUnit()
+		if err != nil {
+			if err == io.EOF {
+				log.Println("End of stream")
+				return nil
+			}
+			log.Printf("Read error: %v", err)
+			continue
+		}
+		frameCount++
+
+		if au.IsKeyframe {
+			nalTypes := ""
+			for _, n := range au.NALs {
+				nalTypes += fmt.Sprintf("%d ", n.Type)
+			}
+			log.Printf("[IDR] Keyframe #%d has %d NALs (types: %s), size=%d",
+				frameCount, len(au.NALs), nalTypes, len(au.Data))
+		}
+
+		if err := a.snd.SendAccessUnitHEVC(au); err != nil {
+			continue
+		}
+
+		if cmd != nil {
+			if bps, ok := a.pacer.ShouldRestartCapture(a.currentBitrateBps); ok {
+				log.Printf("Congestion controller target bitrate moved to %d bps (was %d), restarting capture", bps, a.currentBitrateBps)
+				a.currentBitrateBps = bps
+				cmd.Process.Kill()
+				return errRestartCapture
+			}
+		}
 	}
 }
 
-// receiveLoop handles incoming packets (IDR requests, keepalives)
+// maybeSendHello looks for an SPS NAL in au, parses it, and sends a Hello
+// capabilities announcement populated from the bitstream instead of the
+// hand-configured Width/Height/FPS/Bitrate constants.
+func (a *App) maybeSendHello(au *h264.AccessUnit) {
+	for i := range au.NALs {
+		nal := au.NALs[i]
+		if !nal.IsSPS() {
+			continue
+		}
+
+		sps, err := h264.ParseSPS(&nal)
+		if err != nil {
+			log.Printf("Failed to parse SPS: %v", err)
+			return
+		}
+
+		fpsX10 := uint16(0)
+		if sps.FPS > 0 {
+			fpsX10 = uint16(sps.FPS*10 + 0.5)
+		}
+
+		hello := protocol.Hello{
+			Width:             sps.Width,
+			Height:            sps.Height,
+			FpsX10:            fpsX10,
+			BitrateBps:        a.currentBitrateBps,
+			AVCProfile:        sps.ProfileIDC,
+			AVCLevel:          sps.LevelIDC,
+			IDRIntervalFrames: IDRPeriod,
+		}
+		if a.snd.WireFormat() == sender.WireFormatRTP {
+			hello.Flags |= protocol.HelloFlagWireFormatRTP
+		}
+
+		if err := a.snd.SendHello(hello); err != nil {
+			log.Printf("Failed to send Hello: %v", err)
+			return
+		}
+
+		log.Printf("Hello sent: %dx%d @ %.1ffps, profile=%d level=%d",
+			sps.Width, sps.Height, float64(fpsX10)/10, sps.ProfileIDC, sps.LevelIDC)
+		a.helloSent = true
+		return
+	}
+}
+
+// receiveLoop handles incoming IPv4 packets (IDR requests, keepalives).
 func (a *App) receiveLoop() {
 	defer a.wg.Done()
+	a.receiveFamily(a.netBind.ReceiveIPv4, "v4")
+}
+
+// receiveLoopIPv6 is receiveLoop for the IPv6 socket; only started when
+// a.netBind.HasIPv6() so it never busy-loops on an unusable socket.
+func (a *App) receiveLoopIPv6() {
+	defer a.wg.Done()
+	a.receiveFamily(a.netBind.ReceiveIPv6, "v6")
+}
 
+// receiveFamily is the shared incoming-packet loop run once per address
+// family, over whichever of Bind.ReceiveIPv4/ReceiveIPv6 recv is. netBind is
+// either the plain single-path bind or a *sender.MultiPath merging every
+// bonded path's inbound traffic, transparently to this loop.
+func (a *App) receiveFamily(recv func([]byte) (int, transport.Endpoint, error), label string) {
 	buf := make([]byte, 1500)
 	for {
 		select {
@@ -375,13 +705,13 @@ func (a *App) receiveLoop() {
 		default:
 		}
 
-		a.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
-		n, addr, err := a.conn.ReadFromUDP(buf)
+		a.netBind.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, ep, err := recv(buf)
 		if err != nil {
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 				continue
 			}
-			log.Printf("Receive error: %v", err)
+			log.Printf("Receive error (%s): %v", label, err)
 			continue
 		}
 
@@ -394,10 +724,13 @@ func (a *App) receiveLoop() {
 		msgType := buf[0]
 
 		switch msgType {
+		case protocol.MsgTypeVideoFragment:
+			a.handleReliableFragment(buf[:n])
+
 		case protocol.MsgTypeIDRRequest:
 			var req protocol.IDRRequest
 			if err := req.Unmarshal(buf[:n]); err == nil {
-				log.Printf("IDR request from %s (reason: %d)", addr, req.Reason)
+				log.Printf("IDR request from %s (reason: %d)", ep, req.Reason)
 				// TODO: Signal encoder to emit IDR
 			}
 
@@ -405,16 +738,18 @@ func (a *App) receiveLoop() {
 			var k protocol.Keepalive
 			if err := k.Unmarshal(buf[:n]); err == nil {
 				a.lastRxTsMs = k.TsMs
+				a.handleKeepaliveReport(k)
 			}
 
 		case protocol.MsgTypeProbe:
 			var p protocol.Probe
 			if err := p.Unmarshal(buf[:n]); err == nil {
 				if p.SessionID == a.sessionID && p.Role == protocol.RoleMac {
-					log.Printf("Valid probe from %s", addr)
-					// Update peer address (use actual source)
+					log.Printf("Valid probe from %s", ep)
+					// Update peer endpoint (use actual source, including
+					// the local address it arrived on for reply routing)
 					if a.state == StatePunching {
-						a.peerAddr = addr
+						a.peerEndpoint = ep
 						a.state = StateConnected
 					}
 				}
@@ -423,6 +758,107 @@ func (a *App) receiveLoop() {
 	}
 }
 
+// handleReliableFragment unmarshals buf as a VideoFragment and, if it's
+// carrying sender/reliable's ARQ header on StreamIDReliable rather than
+// video data, hands the segment off to the reliable control stream. Any
+// other VideoFragment arriving here is unexpected on this sender side and
+// is dropped.
+func (a *App) handleReliableFragment(buf []byte) {
+	if a.reliable == nil {
+		return
+	}
+
+	var frag protocol.VideoFragment
+	if err := frag.Unmarshal(buf); err != nil || frag.StreamID != protocol.StreamIDReliable {
+		return
+	}
+	if frag.SessionID != a.sessionID {
+		return
+	}
+	if len(frag.Payload) < protocol.ReliableSegmentHeaderSize {
+		return
+	}
+
+	var seg protocol.ReliableSegment
+	if err := seg.Unmarshal(frag.Payload); err != nil {
+		return
+	}
+
+	end := protocol.ReliableSegmentHeaderSize + int(seg.PayloadLen)
+	if end > len(frag.Payload) {
+		return
+	}
+	a.reliable.HandleSegment(seg, frag.Payload[protocol.ReliableSegmentHeaderSize:end])
+}
+
+// handleKeepaliveReport feeds one inbound Keepalive's receiver-side
+// counters into the congestion controller and into the packetizer's
+// adaptive FEC, and computes this side's own RTT sample from EchoTsMs the
+// same way sender.MultiPath's readLoop does (mirror your own earlier
+// clock reading back, subtract from your current one).
+func (a *App) handleKeepaliveReport(k protocol.Keepalive) {
+	if a.pacer == nil || a.snd == nil {
+		return
+	}
+
+	rttMs := -1.0
+	if k.EchoTsMs != 0 {
+		if rtt := float64(a.snd.ElapsedMs()) - float64(k.EchoTsMs); rtt >= 0 {
+			rttMs = rtt
+		}
+	}
+
+	now := time.Now()
+	windowMs := 0.0
+	if !a.lastCCReportTime.IsZero() {
+		windowMs = now.Sub(a.lastCCReportTime).Seconds() * 1000
+	}
+	a.lastCCReportTime = now
+
+	sentNow := a.snd.Stats().FragmentsSent
+	sentDelta := sentNow - a.lastCCFragSent
+	a.lastCCFragSent = sentNow
+
+	lossFraction := 0.0
+	if sentDelta > 0 {
+		lossFraction = float64(k.PacketsLost) / float64(sentDelta)
+		if lossFraction > 1 {
+			lossFraction = 1
+		}
+	}
+
+	backlog := int(a.snd.CurrentFrameID()) - int(k.HighestFrameID)
+	if backlog < 0 {
+		backlog = 0
+	}
+
+	a.pacer.OnReport(rttMs, k.BytesReceived, windowMs, lossFraction, backlog)
+	a.snd.ReportLoss(lossFraction)
+	if a.reliable != nil && rttMs >= 0 {
+		a.reliable.UpdateRTT(rttMs)
+	}
+}
+
+// reliableLoop drives the reliable control channel's retransmit timer --
+// the whole point of layering ARQ over best-effort UDP (see sender/reliable).
+func (a *App) reliableLoop() {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(ReliableRetransmitIntervalMS * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-ticker.C:
+			if a.reliable != nil {
+				a.reliable.RetransmitTick()
+			}
+		}
+	}
+}
+
 // keepaliveLoop sends periodic keepalives
 func (a *App) keepaliveLoop() {
 	defer a.wg.Done()
@@ -0,0 +1,19 @@
+package mpegts
+
+// crc32MPEG computes the CRC32 variant used by MPEG-2 PSI sections
+// (ISO/IEC 13818-1 Annex A): polynomial 0x04C11DB7, non-reflected, no
+// final XOR, seeded with 0xFFFFFFFF.
+func crc32MPEG(data []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = (crc << 1) ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
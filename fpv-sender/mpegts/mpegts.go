@@ -0,0 +1,374 @@
+// Package mpegts writes a standards-compliant MPEG-2 Transport Stream from
+// a sequence of h264.AccessUnit values, so the received video can be
+// recorded locally (e.g. for post-flight review) without shelling out to
+// ffmpeg.
+package mpegts
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"fpv-sender/h264"
+)
+
+const (
+	tsPacketSize = 188
+	tsHeaderSize = 4
+	syncByte     = 0x47
+
+	patPID         = 0x0000
+	streamTypeH264 = 0x1B
+	streamIDVideo  = 0xE0
+
+	// clockRate is the 90 kHz MPEG timestamp clock used for PTS/DTS/PCR.
+	clockRate = 90
+)
+
+// audNAL is a minimal Annex B Access Unit Delimiter (primary_pic_type =
+// "any slice type"), prepended to AUs that don't already start with one.
+var audNAL = []byte{0x00, 0x00, 0x00, 0x01, 0x09, 0xF0}
+
+// Errors
+var ErrClosed = errors.New("mpegts: writer is closed")
+
+// Options configures the Writer's PIDs and PSI identifiers.
+type Options struct {
+	PMTPID            uint16 // default 0x1000
+	VideoPID          uint16 // default 0x0100
+	ProgramNumber     uint16 // default 1
+	TransportStreamID uint16 // default 1
+}
+
+// DefaultOptions returns the default PID/PSI layout.
+func DefaultOptions() Options {
+	return Options{
+		PMTPID:            0x1000,
+		VideoPID:          0x0100,
+		ProgramNumber:     1,
+		TransportStreamID: 1,
+	}
+}
+
+// Writer muxes H.264 Access Units into an MPEG-2 Transport Stream.
+type Writer struct {
+	w      io.Writer
+	opts   Options
+	cc     map[uint16]byte
+	closed bool
+}
+
+// NewWriter creates a Writer and immediately emits the initial PAT/PMT.
+func NewWriter(w io.Writer, opts Options) *Writer {
+	if opts.PMTPID == 0 {
+		opts.PMTPID = 0x1000
+	}
+	if opts.VideoPID == 0 {
+		opts.VideoPID = 0x0100
+	}
+	if opts.ProgramNumber == 0 {
+		opts.ProgramNumber = 1
+	}
+	if opts.TransportStreamID == 0 {
+		opts.TransportStreamID = 1
+	}
+
+	mw := &Writer{
+		w:    w,
+		opts: opts,
+		cc:   make(map[uint16]byte),
+	}
+	mw.writePAT()
+	mw.writePMT()
+	return mw
+}
+
+// WriteAU muxes one Access Unit as a PES packet spread across TS packets.
+// ptsMs/dtsMs are presentation/decode timestamps in milliseconds (the same
+// clock as VideoFragment.TsMs); dtsMs may equal ptsMs when there is no
+// B-frame reordering, which is always true for this encoder's IDR/P-only
+// GOP structure.
+func (mw *Writer) WriteAU(au *h264.AccessUnit, ptsMs, dtsMs uint32) error {
+	if mw.closed {
+		return ErrClosed
+	}
+
+	// Re-announce PAT/PMT on every keyframe so a recording can be opened
+	// for playback starting at any IDR, not just the first one.
+	if au.IsKeyframe {
+		if err := mw.writePAT(); err != nil {
+			return err
+		}
+		if err := mw.writePMT(); err != nil {
+			return err
+		}
+	}
+
+	data := ensureAUD(au)
+
+	ptsTicks := uint64(ptsMs) * clockRate
+	var dtsTicks *uint64
+	if dtsMs != ptsMs {
+		d := uint64(dtsMs) * clockRate
+		dtsTicks = &d
+	}
+
+	pes := append(buildPESHeader(streamIDVideo, ptsTicks, dtsTicks, len(data)), data...)
+
+	var pcr *uint64
+	if au.IsKeyframe {
+		p := ptsTicks
+		pcr = &p
+	}
+
+	pusi := true
+	for len(pes) > 0 {
+		var framePCR *uint64
+		if pusi {
+			framePCR = pcr
+		}
+
+		capacity := tsPacketSize - tsHeaderSize
+		if framePCR != nil {
+			capacity -= 8 // adaptation_field_length byte + flags byte + 6-byte PCR
+		}
+		chunk := pes
+		if len(chunk) > capacity {
+			chunk = chunk[:capacity]
+		}
+		pes = pes[len(chunk):]
+
+		if err := mw.writePacket(mw.opts.VideoPID, pusi, framePCR, chunk); err != nil {
+			return err
+		}
+		pusi = false
+	}
+
+	return nil
+}
+
+// Close marks the writer closed. The underlying io.Writer is not owned by
+// the Writer and is left for the caller to close.
+func (mw *Writer) Close() error {
+	mw.closed = true
+	return nil
+}
+
+// ensureAUD prepends an AUD NAL if the Access Unit doesn't already start
+// with one, per the TS recording convention of delimiting every frame.
+func ensureAUD(au *h264.AccessUnit) []byte {
+	if len(au.NALs) > 0 && au.NALs[0].Type == h264.NALTypeAUD {
+		return au.Data
+	}
+	data := make([]byte, 0, len(audNAL)+len(au.Data))
+	data = append(data, audNAL...)
+	data = append(data, au.Data...)
+	return data
+}
+
+// writePAT emits the Program Association Table on PID 0x0000.
+func (mw *Writer) writePAT() error {
+	body := []byte{
+		byte(mw.opts.ProgramNumber >> 8), byte(mw.opts.ProgramNumber),
+		0xE0 | byte(mw.opts.PMTPID>>8), byte(mw.opts.PMTPID),
+	}
+	section := wrapPSISection(0x00, mw.opts.TransportStreamID, body)
+	return mw.writeSection(patPID, section)
+}
+
+// writePMT emits the Program Map Table, advertising stream_type 0x1B
+// (H.264) on the video elementary stream PID, which also serves as the PCR
+// PID.
+func (mw *Writer) writePMT() error {
+	body := []byte{
+		0xE0 | byte(mw.opts.VideoPID>>8), byte(mw.opts.VideoPID), // PCR_PID
+		0xF0, 0x00, // program_info_length = 0
+		streamTypeH264,
+		0xE0 | byte(mw.opts.VideoPID>>8), byte(mw.opts.VideoPID),
+		0xF0, 0x00, // ES_info_length = 0
+	}
+	section := wrapPSISection(0x02, mw.opts.ProgramNumber, body)
+	return mw.writeSection(mw.opts.PMTPID, section)
+}
+
+// wrapPSISection builds a complete PSI section (PAT or PMT), including the
+// section_length, version/current_next byte, section numbers and trailing
+// CRC32, given the table-specific body that follows last_section_number.
+func wrapPSISection(tableID uint8, tableIDExtension uint16, body []byte) []byte {
+	sectionLength := 5 + len(body) + 4 // ext(2) + version(1) + section#(1) + last_section#(1) + body + crc(4)
+
+	sec := make([]byte, 0, 3+sectionLength)
+	sec = append(sec, tableID)
+	sec = append(sec, 0xB0|byte(sectionLength>>8), byte(sectionLength))
+	sec = append(sec, byte(tableIDExtension>>8), byte(tableIDExtension))
+	sec = append(sec, 0xC1) // reserved '11' + version 0 + current_next_indicator 1
+	sec = append(sec, 0x00, 0x00)
+	sec = append(sec, body...)
+
+	crc := crc32MPEG(sec)
+	sec = append(sec, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+	return sec
+}
+
+// writeSection wraps a PSI section with its pointer_field and splits it
+// across TS packets (in practice PAT/PMT always fit in one).
+func (mw *Writer) writeSection(pid uint16, section []byte) error {
+	payload := make([]byte, 0, 1+len(section))
+	payload = append(payload, 0x00) // pointer_field: section starts immediately
+	payload = append(payload, section...)
+
+	pusi := true
+	for len(payload) > 0 {
+		chunk := payload
+		if len(chunk) > tsPacketSize-tsHeaderSize {
+			chunk = chunk[:tsPacketSize-tsHeaderSize]
+		}
+		payload = payload[len(chunk):]
+		if err := mw.writePacket(pid, pusi, nil, chunk); err != nil {
+			return err
+		}
+		pusi = false
+	}
+	return nil
+}
+
+// writePacket writes one 188-byte TS packet. If payload leaves spare room
+// (184 bytes of capacity, less if a PCR adaptation field is present), the
+// remainder is filled with adaptation-field stuffing rather than splitting
+// the caller's payload further.
+func (mw *Writer) writePacket(pid uint16, pusi bool, pcr *uint64, payload []byte) error {
+	const maxPlain = tsPacketSize - tsHeaderSize
+
+	pcrLen := 0
+	if pcr != nil {
+		pcrLen = 6
+	}
+
+	cc := mw.nextCC(pid)
+	pkt := make([]byte, 0, tsPacketSize)
+	pkt = append(pkt, syncByte)
+	pusiBit := byte(0)
+	if pusi {
+		pusiBit = 0x40
+	}
+	pkt = append(pkt, pusiBit|byte(pid>>8)&0x1F, byte(pid))
+
+	if pcr == nil && len(payload) == maxPlain {
+		pkt = append(pkt, 0x10|cc) // adaptation_field_control = 01 (payload only)
+		pkt = append(pkt, payload...)
+		_, err := mw.w.Write(pkt)
+		return err
+	}
+
+	if pcr == nil && len(payload) == maxPlain-1 {
+		// adaptation_field_length = 0 is legal and carries no flags byte at
+		// all -- the length byte is the entire adaptation field. It's the
+		// only way to reach exactly this payload size: the flags-byte form
+		// below always costs at least 2 bytes of adaptation field overhead,
+		// so it can only reach maxPlain-2 and below.
+		pkt = append(pkt, 0x30|cc) // adaptation_field_control = 11 (adaptation field + payload)
+		pkt = append(pkt, 0)       // adaptation_field_length
+		pkt = append(pkt, payload...)
+
+		if len(pkt) != tsPacketSize {
+			return fmt.Errorf("mpegts: internal packet size mismatch: got %d want %d", len(pkt), tsPacketSize)
+		}
+		_, err := mw.w.Write(pkt)
+		return err
+	}
+
+	stuffing := maxPlain - 1 /* adaptation_field_length byte */ - 1 /* flags byte */ - pcrLen - len(payload)
+	if stuffing < 0 {
+		return fmt.Errorf("mpegts: payload of %d bytes exceeds TS packet capacity", len(payload))
+	}
+	afLen := 1 + pcrLen + stuffing
+
+	pkt = append(pkt, 0x30|cc) // adaptation_field_control = 11 (adaptation field + payload)
+	pkt = append(pkt, byte(afLen))
+	flags := byte(0)
+	if pcr != nil {
+		flags |= 0x10 // PCR_flag
+	}
+	pkt = append(pkt, flags)
+	if pcr != nil {
+		pkt = append(pkt, encodePCR(*pcr)...)
+	}
+	for i := 0; i < stuffing; i++ {
+		pkt = append(pkt, 0xFF)
+	}
+	pkt = append(pkt, payload...)
+
+	if len(pkt) != tsPacketSize {
+		return fmt.Errorf("mpegts: internal packet size mismatch: got %d want %d", len(pkt), tsPacketSize)
+	}
+	_, err := mw.w.Write(pkt)
+	return err
+}
+
+// nextCC returns the current continuity counter for pid and advances it.
+func (mw *Writer) nextCC(pid uint16) byte {
+	cc := mw.cc[pid]
+	mw.cc[pid] = (cc + 1) & 0x0F
+	return cc
+}
+
+// encodePCR encodes a 33-bit PCR base (90kHz) with a zero 9-bit extension
+// into the 6-byte program_clock_reference field.
+func encodePCR(base uint64) []byte {
+	base &= (1 << 33) - 1
+	b := make([]byte, 6)
+	b[0] = byte(base >> 25)
+	b[1] = byte(base >> 17)
+	b[2] = byte(base >> 9)
+	b[3] = byte(base >> 1)
+	b[4] = byte(base<<7) | 0x7E
+	b[5] = 0x00
+	return b
+}
+
+// buildPESHeader builds a PES packet header with data_alignment_indicator
+// set (each PES payload starts exactly on an Access Unit boundary).
+func buildPESHeader(streamID byte, ptsTicks uint64, dtsTicks *uint64, payloadLen int) []byte {
+	headerDataLen := 5
+	if dtsTicks != nil {
+		headerDataLen = 10
+	}
+
+	pesLen := 3 + headerDataLen + payloadLen
+	if pesLen > 0xFFFF {
+		pesLen = 0 // unbounded length, permitted for video elementary streams
+	}
+
+	h := make([]byte, 0, 9+headerDataLen)
+	h = append(h, 0x00, 0x00, 0x01, streamID)
+	h = append(h, byte(pesLen>>8), byte(pesLen))
+	h = append(h, 0x84) // '10' marker, scrambling=00, priority=0, data_alignment=1
+
+	ptsDtsFlags := byte(0x80) // '10': PTS only
+	if dtsTicks != nil {
+		ptsDtsFlags = 0xC0 // '11': PTS and DTS
+	}
+	h = append(h, ptsDtsFlags)
+	h = append(h, byte(headerDataLen))
+
+	if dtsTicks != nil {
+		h = append(h, encodeTimestamp(0x3, ptsTicks)...)
+		h = append(h, encodeTimestamp(0x1, *dtsTicks)...)
+	} else {
+		h = append(h, encodeTimestamp(0x2, ptsTicks)...)
+	}
+	return h
+}
+
+// encodeTimestamp encodes a 33-bit PTS/DTS value into the 5-byte format
+// with the given 4-bit prefix ('0010' PTS-only, '0011' PTS, '0001' DTS).
+func encodeTimestamp(prefix byte, ts uint64) []byte {
+	ts &= (1 << 33) - 1
+	b := make([]byte, 5)
+	b[0] = (prefix << 4) | byte((ts>>29)&0x0E) | 0x01
+	b[1] = byte(ts >> 22)
+	b[2] = byte((ts>>14)&0xFE) | 0x01
+	b[3] = byte(ts >> 7)
+	b[4] = byte((ts<<1)&0xFE) | 0x01
+	return b
+}
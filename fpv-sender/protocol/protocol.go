@@ -27,11 +27,27 @@ const (
 const (
 	FlagKeyframe = 1 << 0 // bit0: IDR frame
 	FlagSPSPPS   = 1 << 1 // bit1: contains SPS/PPS
+	FlagFEC      = 1 << 2 // bit2: Payload is a Reed-Solomon parity shard, see FECShardHeader
 )
 
 // Codec types
 const (
 	CodecH264 = 1
+	CodecH265 = 2
+)
+
+// Stream IDs, carried in VideoFragment.StreamID. sender.DefaultConfig uses
+// StreamIDVideo for the primary video stream; StreamIDReliable is reserved
+// for sender/reliable's ARQ control channel riding inside the same framing
+// (see ReliableSegment) instead of opening a second port.
+const (
+	StreamIDReliable = 0
+	StreamIDVideo    = 1
+)
+
+// Hello flags
+const (
+	HelloFlagWireFormatRTP = 1 << 0 // bit0: video is carried as RFC 6184 RTP, not VideoFragment framing
 )
 
 // Roles
@@ -50,12 +66,22 @@ const (
 
 // Header sizes
 const (
-	CommonHeaderSize        = 8
-	VideoFragmentHeaderSize = 28
-	KeepaliveHeaderSize     = 20
-	IDRRequestHeaderSize    = 20
-	ProbeHeaderSize         = 28
-	HelloHeaderSize         = 32
+	CommonHeaderSize          = 8
+	VideoFragmentHeaderSize   = 29
+	KeepaliveHeaderSize       = 32
+	IDRRequestHeaderSize      = 20
+	ProbeHeaderSize           = 28
+	HelloHeaderSize           = 32
+	FECShardHeaderSize        = 8
+	ReliableSegmentHeaderSize = 16
+
+	// FECShardLenPrefixSize is a big-endian uint16 real-length prefix that
+	// the sender and reassembler both bake into a FEC data shard before it
+	// enters the GF(2^8) arithmetic. Shards in a group are zero-padded to
+	// the group's longest fragment, and this is how a reconstructed one
+	// gets that padding trimmed back off. It lives here rather than in
+	// sender/fec because both sides of the wire must agree on it.
+	FECShardLenPrefixSize = 2
 )
 
 // Errors
@@ -114,7 +140,13 @@ func (h *CommonHeader) Unmarshal(buf []byte) error {
 //	    24 |    1 | u8    | flags
 //	    25 |    1 | u8    | codec
 //	    26 |    2 | u16   | payload_len
-//	    28 |    N | bytes | payload
+//	    28 |    1 | u8    | path_id
+//	    29 |    N | bytes | payload
+//
+// path_id identifies which of a sender.MultiPath's bonded links this
+// fragment went out on (0 for a single-path sender), letting a receiver
+// dedupe PolicyDuplicate's repeated (FrameID, FragIndex) sends and tally
+// loss per path.
 type VideoFragment struct {
 	SessionID  uint32
 	StreamID   uint32
@@ -125,6 +157,7 @@ type VideoFragment struct {
 	Flags      uint8
 	Codec      uint8
 	PayloadLen uint16
+	PathID     uint8
 	Payload    []byte
 }
 
@@ -150,9 +183,10 @@ func (v *VideoFragment) Marshal(buf []byte) (int, error) {
 	buf[24] = v.Flags
 	buf[25] = v.Codec
 	binary.BigEndian.PutUint16(buf[26:28], uint16(len(v.Payload)))
+	buf[28] = v.PathID
 
 	// Payload
-	copy(buf[28:], v.Payload)
+	copy(buf[29:], v.Payload)
 	return total, nil
 }
 
@@ -177,11 +211,12 @@ func (v *VideoFragment) Unmarshal(buf []byte) error {
 	v.Flags = buf[24]
 	v.Codec = buf[25]
 	v.PayloadLen = binary.BigEndian.Uint16(buf[26:28])
+	v.PathID = buf[28]
 
 	if len(buf) < VideoFragmentHeaderSize+int(v.PayloadLen) {
 		return ErrBufferTooSmall
 	}
-	v.Payload = buf[28 : 28+v.PayloadLen]
+	v.Payload = buf[29 : 29+v.PayloadLen]
 	return nil
 }
 
@@ -195,17 +230,154 @@ func (v *VideoFragment) HasSPSPPS() bool {
 	return v.Flags&FlagSPSPPS != 0
 }
 
-// Keepalive is msg_type=0x02, sent both directions.
+// IsFEC returns true if this fragment's payload is a Reed-Solomon parity
+// shard (a FECShardHeader followed by shard bytes) rather than AU data.
+func (v *VideoFragment) IsFEC() bool {
+	return v.Flags&FlagFEC != 0
+}
+
+// videoFragmentPathIDOffset is the path_id byte's offset within an
+// already-marshaled VideoFragment.
+const videoFragmentPathIDOffset = 28
+
+// SetPathID overwrites the path_id byte of an already-marshaled
+// VideoFragment in place. sender.MultiPath uses this to re-stamp one
+// marshaled fragment per outgoing path (PolicyDuplicate's per-path copies,
+// or PolicyWeighted's single chosen path) without re-running Marshal.
+func SetPathID(buf []byte, pathID uint8) {
+	buf[videoFragmentPathIDOffset] = pathID
+}
+
+// FECShardHeader prefixes the Payload of a VideoFragment sent with FlagFEC
+// set, describing the Reed-Solomon shard group a parity shard belongs to.
+// It rides inside the payload rather than the fixed 29-byte VideoFragment
+// header so a receiver that only understands plain VideoFragment framing
+// can still parse the outer header; FrameID identifies the Access Unit
+// being protected and FragIndex/FragCount take on group-relative meaning
+// (the parity shard's index 0..m-1 within its group, and m) instead of
+// their normal per-AU meaning.
+//
+//	Offset | Size | Type | Name
+//	     0 |    4 | u32  | shard_group
+//	     4 |    1 | u8   | k
+//	     5 |    1 | u8   | m
+//	     6 |    2 | bytes | reserved
+type FECShardHeader struct {
+	ShardGroup uint32
+	K          uint8
+	M          uint8
+}
+
+// Marshal writes the FEC shard header to buf (must be >= FECShardHeaderSize).
+func (f *FECShardHeader) Marshal(buf []byte) error {
+	if len(buf) < FECShardHeaderSize {
+		return ErrBufferTooSmall
+	}
+	binary.BigEndian.PutUint32(buf[0:4], f.ShardGroup)
+	buf[4] = f.K
+	buf[5] = f.M
+	buf[6] = 0 // reserved
+	buf[7] = 0
+	return nil
+}
+
+// Unmarshal reads a FEC shard header from buf.
+func (f *FECShardHeader) Unmarshal(buf []byte) error {
+	if len(buf) < FECShardHeaderSize {
+		return ErrBufferTooSmall
+	}
+	f.ShardGroup = binary.BigEndian.Uint32(buf[0:4])
+	f.K = buf[4]
+	f.M = buf[5]
+	return nil
+}
+
+// Reliable segment flags
+const (
+	FlagReliableACKOnly = 1 << 0 // bit0: no payload; seq is unused, only ack/window carry information
+)
+
+// ReliableSegment is sender/reliable's ARQ header. Like FECShardHeader, it
+// rides inside a VideoFragment's Payload rather than getting its own
+// msg_type, but it's distinguished by StreamID == StreamIDReliable instead
+// of a flag bit, since it replaces the whole message rather than sharing it
+// with AU fragment data. It gives IDR requests, encoder-parameter changes,
+// and eventually the P2P signaling handshake in-order, retransmitted
+// delivery over the same socket as video instead of the best-effort UDP
+// those otherwise get.
+//
+//	Offset | Size | Type  | Name
+//	     0 |    4 | u32   | seq
+//	     4 |    4 | u32   | ack
+//	     8 |    2 | u16   | window
+//	    10 |    1 | u8    | flags
+//	    11 |    1 | bytes | reserved
+//	    12 |    2 | u16   | payload_len
+//	    14 |    2 | bytes | reserved
+type ReliableSegment struct {
+	Seq        uint32
+	Ack        uint32
+	Window     uint16
+	Flags      uint8
+	PayloadLen uint16
+}
+
+// Marshal writes the reliable segment header to buf (must be >=
+// ReliableSegmentHeaderSize). Unlike VideoFragment.Marshal, it doesn't
+// append the payload itself -- sender/reliable builds the two pieces
+// together the same way Packetizer.fecShardPackets does for
+// FECShardHeader.
+func (r *ReliableSegment) Marshal(buf []byte) error {
+	if len(buf) < ReliableSegmentHeaderSize {
+		return ErrBufferTooSmall
+	}
+	binary.BigEndian.PutUint32(buf[0:4], r.Seq)
+	binary.BigEndian.PutUint32(buf[4:8], r.Ack)
+	binary.BigEndian.PutUint16(buf[8:10], r.Window)
+	buf[10] = r.Flags
+	buf[11] = 0 // reserved
+	binary.BigEndian.PutUint16(buf[12:14], r.PayloadLen)
+	buf[14] = 0 // reserved
+	buf[15] = 0
+	return nil
+}
+
+// Unmarshal reads a reliable segment header from buf.
+func (r *ReliableSegment) Unmarshal(buf []byte) error {
+	if len(buf) < ReliableSegmentHeaderSize {
+		return ErrBufferTooSmall
+	}
+	r.Seq = binary.BigEndian.Uint32(buf[0:4])
+	r.Ack = binary.BigEndian.Uint32(buf[4:8])
+	r.Window = binary.BigEndian.Uint16(buf[8:10])
+	r.Flags = buf[10]
+	r.PayloadLen = binary.BigEndian.Uint16(buf[12:14])
+	return nil
+}
+
+// Keepalive is msg_type=0x02, sent both directions. bytes_received,
+// packets_lost, and highest_frame_id are a receiver's report of what it
+// saw over the window since its previous Keepalive -- a sender's
+// congestion controller (see sender/cc) folds them into its bandwidth and
+// loss estimate the same way EchoTsMs already feeds its RTT estimate.
+// They're meaningless (read as 0) on a Keepalive sent upstream by a pure
+// sender that isn't also receiving video, e.g. the Pi side.
 //
 //	Offset | Size | Type | Name
 //	     8 |    4 | u32  | ts_ms
 //	    12 |    4 | u32  | seq
 //	    16 |    4 | u32  | echo_ts_ms
+//	    20 |    4 | u32  | bytes_received
+//	    24 |    4 | u32  | packets_lost
+//	    28 |    4 | u32  | highest_frame_id
 type Keepalive struct {
-	SessionID uint32
-	TsMs      uint32
-	Seq       uint32
-	EchoTsMs  uint32
+	SessionID      uint32
+	TsMs           uint32
+	Seq            uint32
+	EchoTsMs       uint32
+	BytesReceived  uint32
+	PacketsLost    uint32
+	HighestFrameID uint32
 }
 
 // Marshal writes the keepalive to buf. Returns bytes written.
@@ -221,6 +393,9 @@ func (k *Keepalive) Marshal(buf []byte) (int, error) {
 	binary.BigEndian.PutUint32(buf[8:12], k.TsMs)
 	binary.BigEndian.PutUint32(buf[12:16], k.Seq)
 	binary.BigEndian.PutUint32(buf[16:20], k.EchoTsMs)
+	binary.BigEndian.PutUint32(buf[20:24], k.BytesReceived)
+	binary.BigEndian.PutUint32(buf[24:28], k.PacketsLost)
+	binary.BigEndian.PutUint32(buf[28:32], k.HighestFrameID)
 	return KeepaliveHeaderSize, nil
 }
 
@@ -240,6 +415,9 @@ func (k *Keepalive) Unmarshal(buf []byte) error {
 	k.TsMs = binary.BigEndian.Uint32(buf[8:12])
 	k.Seq = binary.BigEndian.Uint32(buf[12:16])
 	k.EchoTsMs = binary.BigEndian.Uint32(buf[16:20])
+	k.BytesReceived = binary.BigEndian.Uint32(buf[20:24])
+	k.PacketsLost = binary.BigEndian.Uint32(buf[24:28])
+	k.HighestFrameID = binary.BigEndian.Uint32(buf[28:32])
 	return nil
 }
 
@@ -364,16 +542,22 @@ func (p *Probe) Unmarshal(buf []byte) error {
 //	    18 |    1 | u8    | avc_profile
 //	    19 |    1 | u8    | avc_level
 //	    20 |    4 | u32   | idr_interval_frames
-//	    24 |    8 | bytes | reserved
+//	    24 |    1 | u8    | flags
+//	    25 |    1 | u8    | hevc_profile_tier (valid when codec is CodecH265)
+//	    26 |    1 | u8    | hevc_level (valid when codec is CodecH265)
+//	    27 |    5 | bytes | reserved
 type Hello struct {
 	SessionID         uint32
 	Width             uint16
 	Height            uint16
 	FpsX10            uint16
 	BitrateBps        uint32
-	AVCProfile        uint8
-	AVCLevel          uint8
+	AVCProfile        uint8 // valid when the stream codec is CodecH264
+	AVCLevel          uint8 // valid when the stream codec is CodecH264
 	IDRIntervalFrames uint32
+	Flags             uint8
+	HEVCProfileTier   uint8 // valid when the stream codec is CodecH265
+	HEVCLevel         uint8 // valid when the stream codec is CodecH265
 }
 
 // Marshal writes the hello to buf. Returns bytes written.
@@ -393,8 +577,11 @@ func (h *Hello) Marshal(buf []byte) (int, error) {
 	buf[18] = h.AVCProfile
 	buf[19] = h.AVCLevel
 	binary.BigEndian.PutUint32(buf[20:24], h.IDRIntervalFrames)
-	// reserved bytes 24-31
-	for i := 24; i < 32; i++ {
+	buf[24] = h.Flags
+	buf[25] = h.HEVCProfileTier
+	buf[26] = h.HEVCLevel
+	// reserved bytes 27-31
+	for i := 27; i < 32; i++ {
 		buf[i] = 0
 	}
 	return HelloHeaderSize, nil
@@ -420,6 +607,9 @@ func (h *Hello) Unmarshal(buf []byte) error {
 	h.AVCProfile = buf[18]
 	h.AVCLevel = buf[19]
 	h.IDRIntervalFrames = binary.BigEndian.Uint32(buf[20:24])
+	h.Flags = buf[24]
+	h.HEVCProfileTier = buf[25]
+	h.HEVCLevel = buf[26]
 	return nil
 }
 
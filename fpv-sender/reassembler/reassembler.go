@@ -0,0 +1,461 @@
+// Package reassembler consumes protocol.VideoFragment packets on the
+// receiver side and emits complete h264.AccessUnit values, with a small
+// jitter buffer and loss-triggered IDR requests.
+package reassembler
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"fpv-sender/fec"
+	"fpv-sender/h264"
+	"fpv-sender/protocol"
+)
+
+// Config holds reassembler tuning parameters.
+type Config struct {
+	RingSize       int           // number of in-flight frames tracked, default 32
+	JitterDeadline time.Duration // max time to wait for a frame's remaining fragments, default 60ms
+	MinIDRInterval time.Duration // rate limit between IDR requests, default 200ms
+}
+
+// DefaultConfig returns the default reassembler configuration.
+func DefaultConfig() Config {
+	return Config{
+		RingSize:       32,
+		JitterDeadline: 60 * time.Millisecond,
+		MinIDRInterval: 200 * time.Millisecond,
+	}
+}
+
+// Stats holds a snapshot of reassembler counters.
+type Stats struct {
+	FragmentsReceived uint64
+	FramesDelivered   uint64
+	FramesDropped     uint64
+	IDRRequestsSent   uint64
+	JitterEstimateMs  float64
+	FECFragsRecovered uint64 // data fragments recovered via Reed-Solomon, avoiding an IDR
+}
+
+// fecGroup buffers the parity shards received for one Reed-Solomon shard
+// group (protocol.FECShardHeader.ShardGroup), i.e. one run of up to K data
+// fragments of a frame plus their M parity fragments.
+type fecGroup struct {
+	k, m   int
+	parity [][]byte // length m, nil entries not yet arrived
+}
+
+// frameBuf accumulates fragments for a single in-flight Access Unit.
+type frameBuf struct {
+	frameID      uint32
+	fragCount    uint16
+	fragments    map[uint16][]byte
+	flags        uint8
+	firstArrival time.Time
+	complete     bool
+
+	// FEC bookkeeping, nil until the first FlagFEC fragment for this frame
+	// arrives. Shard groups are keyed by their rank (0, 1, 2, ...) within
+	// the frame, which this reassembler infers from the order distinct
+	// ShardGroup IDs are first seen -- the sender always finishes one
+	// group's data and parity fragments before starting the next, so
+	// arrival order within the jitter window matches send order.
+	//
+	// A group's own K (fecGroup.k) is its real data-shard count, which is
+	// smaller than the packetizer's configured K for an AU's trailing
+	// group. Converting a rank to a FragIndex range needs that configured
+	// K, not the maybe-smaller real count, so fecStride tracks the largest
+	// K seen across the frame's groups as a stand-in for it.
+	fecGroupRank map[uint32]int
+	fecGroups    map[int]*fecGroup
+	fecStride    int
+}
+
+// Reassembler reassembles VideoFragment packets into h264.AccessUnit
+// values, dropping and requesting an IDR for frames that don't complete
+// within the jitter deadline.
+type Reassembler struct {
+	cfg          Config
+	onIDRRequest func(reason uint8)
+
+	mu           sync.Mutex
+	ring         []*frameBuf
+	nextExpected uint32
+	haveExpected bool
+
+	lastArrival      time.Time
+	jitterEstimateMs float64
+
+	lastIDRReqTime    time.Time
+	lastIDRReqFrameID uint32
+	haveLastIDRReq    bool
+
+	fecCodecs map[[2]int]*fec.Codec // cached by (k, m), built lazily
+
+	stats Stats
+}
+
+// New creates a Reassembler. onIDRRequest is invoked (with the request
+// reason to use) whenever the reassembler decides an IDR is needed; the
+// caller is expected to actually send the protocol.IDRRequest packet.
+func New(cfg Config, onIDRRequest func(reason uint8)) *Reassembler {
+	if cfg.RingSize <= 0 {
+		cfg.RingSize = 32
+	}
+	if cfg.JitterDeadline <= 0 {
+		cfg.JitterDeadline = 60 * time.Millisecond
+	}
+	if cfg.MinIDRInterval <= 0 {
+		cfg.MinIDRInterval = 200 * time.Millisecond
+	}
+	return &Reassembler{
+		cfg:          cfg,
+		onIDRRequest: onIDRRequest,
+		ring:         make([]*frameBuf, cfg.RingSize),
+	}
+}
+
+// Push feeds one VideoFragment into the reassembler. It returns any
+// AccessUnits that became ready for delivery as a result (usually zero or
+// one, but a gap closing can release several at once, in frame order).
+func (r *Reassembler) Push(frag *protocol.VideoFragment, now time.Time) []*h264.AccessUnit {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stats.FragmentsReceived++
+	r.updateJitter(now)
+
+	if !r.haveExpected {
+		r.nextExpected = frag.FrameID
+		r.haveExpected = true
+	}
+	if protocol.IsOlder(frag.FrameID, r.nextExpected) {
+		return nil // fragment belongs to a frame we already delivered or dropped
+	}
+
+	idx := int(frag.FrameID % uint32(len(r.ring)))
+	fb := r.ring[idx]
+	if fb == nil || fb.frameID != frag.FrameID {
+		fb = &frameBuf{
+			frameID:      frag.FrameID,
+			fragments:    make(map[uint16][]byte, frag.FragCount),
+			firstArrival: now,
+		}
+		r.ring[idx] = fb
+	}
+
+	if frag.IsFEC() {
+		r.pushFEC(fb, frag)
+	} else {
+		// A frame's fragCount and flags ride on every one of its data
+		// fragments, so any of them can supply these -- including one
+		// arriving after a FlagFEC fragment already created fb.
+		fb.fragCount = frag.FragCount
+		fb.flags = frag.Flags
+		if _, exists := fb.fragments[frag.FragIndex]; !exists {
+			payload := make([]byte, len(frag.Payload))
+			copy(payload, frag.Payload)
+			fb.fragments[frag.FragIndex] = payload
+		}
+	}
+
+	if fb.fragCount == 0 || uint16(len(fb.fragments)) < fb.fragCount {
+		r.tryReconstructFEC(fb)
+	}
+	if fb.fragCount > 0 && uint16(len(fb.fragments)) >= fb.fragCount {
+		fb.complete = true
+	}
+
+	return r.drainHead(now)
+}
+
+// pushFEC buffers one FlagFEC fragment's parity shard into its frame's shard
+// group, keyed by the FECShardHeader.ShardGroup carried in the payload.
+// Malformed FEC payloads are dropped silently, same as any other corrupt
+// packet -- the jitter deadline and IDR fallback cover for it.
+func (r *Reassembler) pushFEC(fb *frameBuf, frag *protocol.VideoFragment) {
+	var hdr protocol.FECShardHeader
+	if err := hdr.Unmarshal(frag.Payload); err != nil || hdr.K == 0 || hdr.M == 0 {
+		return
+	}
+	shardData := frag.Payload[protocol.FECShardHeaderSize:]
+
+	if fb.fecGroupRank == nil {
+		fb.fecGroupRank = make(map[uint32]int)
+		fb.fecGroups = make(map[int]*fecGroup)
+	}
+	rank, ok := fb.fecGroupRank[hdr.ShardGroup]
+	if !ok {
+		rank = len(fb.fecGroupRank)
+		fb.fecGroupRank[hdr.ShardGroup] = rank
+	}
+	g := fb.fecGroups[rank]
+	if g == nil {
+		g = &fecGroup{k: int(hdr.K), m: int(hdr.M), parity: make([][]byte, hdr.M)}
+		fb.fecGroups[rank] = g
+	}
+	if int(hdr.K) > fb.fecStride {
+		fb.fecStride = int(hdr.K)
+	}
+	// fragCount normally comes from a data fragment, but if a frame's last
+	// data fragment is itself the one lost, nothing ever supplies it -- so
+	// treat the span this shard group covers as a lower-bound estimate.
+	// An actual data fragment arriving later always overwrites this with
+	// the authoritative count.
+	if end := uint16(rank*fb.fecStride + int(hdr.K)); end > fb.fragCount {
+		fb.fragCount = end
+	}
+	if int(frag.FragIndex) < len(g.parity) && g.parity[frag.FragIndex] == nil {
+		shard := make([]byte, len(shardData))
+		copy(shard, shardData)
+		g.parity[frag.FragIndex] = shard
+	}
+}
+
+// tryReconstructFEC looks for a buffered shard group with at least K of its
+// K+M shards (data fragments already in fb.fragments plus buffered parity)
+// present, and Reed-Solomon decodes any still-missing data fragments in
+// that group's range. It runs eagerly on every Push rather than waiting out
+// a separate deadline, since reconstructing as soon as possible only helps.
+func (r *Reassembler) tryReconstructFEC(fb *frameBuf) {
+	if fb.fragCount == 0 {
+		return
+	}
+	for rank, g := range fb.fecGroups {
+		start := rank * fb.fecStride
+		if start >= int(fb.fragCount) {
+			continue
+		}
+		end := start + g.k
+		if end > int(fb.fragCount) {
+			end = int(fb.fragCount)
+		}
+
+		missing := false
+		for i := start; i < end; i++ {
+			if _, ok := fb.fragments[uint16(i)]; !ok {
+				missing = true
+				break
+			}
+		}
+		if !missing {
+			continue
+		}
+
+		shardLen := protocol.FECShardLenPrefixSize
+		for i := start; i < end; i++ {
+			if data, ok := fb.fragments[uint16(i)]; ok && len(data)+protocol.FECShardLenPrefixSize > shardLen {
+				shardLen = len(data) + protocol.FECShardLenPrefixSize
+			}
+		}
+		for _, p := range g.parity {
+			if len(p) > shardLen {
+				shardLen = len(p)
+			}
+		}
+
+		shards := make([][]byte, g.k+g.m)
+		present := make([]bool, g.k+g.m)
+		for i := start; i < end; i++ {
+			if data, ok := fb.fragments[uint16(i)]; ok {
+				// Re-wrap in the same length-prefix-and-pad encoding the
+				// sender's Encode used, so this shard lines up byte-for-byte
+				// with the still-missing ones it's helping reconstruct.
+				shard := make([]byte, shardLen)
+				binary.BigEndian.PutUint16(shard[:protocol.FECShardLenPrefixSize], uint16(len(data)))
+				copy(shard[protocol.FECShardLenPrefixSize:], data)
+				shards[i-start] = shard
+				present[i-start] = true
+			}
+		}
+		for i, p := range g.parity {
+			if p != nil {
+				shards[g.k+i] = p
+				present[g.k+i] = true
+			}
+		}
+
+		codec, err := r.fecCodecFor(g.k, g.m)
+		if err != nil {
+			continue
+		}
+		if err := codec.Reconstruct(shards, present); err != nil {
+			continue // not enough shards yet, try again once more arrive
+		}
+
+		for i := start; i < end; i++ {
+			if _, ok := fb.fragments[uint16(i)]; ok {
+				continue
+			}
+			fb.fragments[uint16(i)] = trimFECShard(shards[i-start])
+			r.stats.FECFragsRecovered++
+		}
+	}
+}
+
+// trimFECShard strips the length prefix and zero padding a FEC data shard
+// was given before GF(2^8) encoding, recovering the original fragment bytes.
+func trimFECShard(shard []byte) []byte {
+	if len(shard) < protocol.FECShardLenPrefixSize {
+		return nil
+	}
+	n := int(binary.BigEndian.Uint16(shard[:protocol.FECShardLenPrefixSize]))
+	if n > len(shard)-protocol.FECShardLenPrefixSize {
+		n = len(shard) - protocol.FECShardLenPrefixSize
+	}
+	out := make([]byte, n)
+	copy(out, shard[protocol.FECShardLenPrefixSize:protocol.FECShardLenPrefixSize+n])
+	return out
+}
+
+// fecCodecFor returns the cached Reed-Solomon codec for (k, m), building one
+// on first use.
+func (r *Reassembler) fecCodecFor(k, m int) (*fec.Codec, error) {
+	key := [2]int{k, m}
+	if c, ok := r.fecCodecs[key]; ok {
+		return c, nil
+	}
+	c, err := fec.New(k, m)
+	if err != nil {
+		return nil, err
+	}
+	if r.fecCodecs == nil {
+		r.fecCodecs = make(map[[2]int]*fec.Codec)
+	}
+	r.fecCodecs[key] = c
+	return c, nil
+}
+
+// Tick drives the jitter deadline forward even when no new fragments
+// arrive, so a stalled head-of-line frame is eventually dropped. Callers
+// should invoke it periodically (e.g. every 10-20ms).
+func (r *Reassembler) Tick(now time.Time) []*h264.AccessUnit {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.drainHead(now)
+}
+
+// drainHead delivers or drops consecutive frames starting at nextExpected,
+// stopping at the first still-incomplete frame within its jitter deadline.
+func (r *Reassembler) drainHead(now time.Time) []*h264.AccessUnit {
+	var out []*h264.AccessUnit
+	for {
+		idx := int(r.nextExpected % uint32(len(r.ring)))
+		fb := r.ring[idx]
+
+		if fb != nil && fb.frameID != r.nextExpected {
+			// nextExpected's own slot has already been recycled by a later
+			// frame -- the gap since nextExpected exceeded RingSize, so its
+			// fragments were never all going to arrive in time anyway.
+			// FrameIDs only increase, so this slot can never again hold
+			// nextExpected: drop it outright instead of returning and
+			// stalling here forever waiting on a frame that will never show.
+			r.stats.FramesDropped++
+			r.maybeRequestIDR(now, r.nextExpected)
+			r.nextExpected++
+			continue
+		}
+		if fb == nil {
+			return out
+		}
+
+		if fb.complete {
+			out = append(out, assembleAU(fb))
+			r.ring[idx] = nil
+			r.nextExpected++
+			r.stats.FramesDelivered++
+			continue
+		}
+
+		if now.Sub(fb.firstArrival) < r.cfg.JitterDeadline {
+			return out
+		}
+
+		r.stats.FramesDropped++
+		r.maybeRequestIDR(now, fb.frameID)
+		r.ring[idx] = nil
+		r.nextExpected++
+	}
+}
+
+// maybeRequestIDR enqueues an IDR request, rate-limited to at most one per
+// MinIDRInterval and at most one per unique dropped frame.
+func (r *Reassembler) maybeRequestIDR(now time.Time, frameID uint32) {
+	if r.haveLastIDRReq && r.lastIDRReqFrameID == frameID {
+		return
+	}
+	if !r.lastIDRReqTime.IsZero() && now.Sub(r.lastIDRReqTime) < r.cfg.MinIDRInterval {
+		return
+	}
+
+	r.lastIDRReqTime = now
+	r.lastIDRReqFrameID = frameID
+	r.haveLastIDRReq = true
+	r.stats.IDRRequestsSent++
+
+	if r.onIDRRequest != nil {
+		r.onIDRRequest(protocol.IDRReasonLoss)
+	}
+}
+
+// updateJitter maintains an RFC 3550-style EWMA of the inter-arrival delta
+// between consecutive fragment arrivals, in milliseconds.
+func (r *Reassembler) updateJitter(now time.Time) {
+	if !r.lastArrival.IsZero() {
+		delta := float64(now.Sub(r.lastArrival).Microseconds()) / 1000
+		r.jitterEstimateMs += (delta - r.jitterEstimateMs) / 16
+	}
+	r.lastArrival = now
+}
+
+// Stats returns a snapshot of the current counters.
+func (r *Reassembler) Stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.stats
+	s.JitterEstimateMs = r.jitterEstimateMs
+	return s
+}
+
+// assembleAU concatenates a frame's fragments in FragIndex order into a
+// complete AccessUnit. The sender fragments the already-serialized Annex B
+// byte stream rather than splitting on NAL boundaries, so ordered
+// concatenation reconstructs valid Annex B without needing to insert start
+// codes; as a defensive fallback for any sender that fragments per-NAL, one
+// is prepended if the result doesn't already begin with one.
+func assembleAU(fb *frameBuf) *h264.AccessUnit {
+	total := 0
+	for _, frag := range fb.fragments {
+		total += len(frag)
+	}
+
+	data := make([]byte, 0, total+4)
+	for i := uint16(0); i < fb.fragCount; i++ {
+		data = append(data, fb.fragments[i]...)
+	}
+
+	if !hasStartCode(data) {
+		prefixed := make([]byte, 0, len(data)+4)
+		prefixed = append(prefixed, 0x00, 0x00, 0x00, 0x01)
+		prefixed = append(prefixed, data...)
+		data = prefixed
+	}
+
+	return &h264.AccessUnit{
+		IsKeyframe: fb.flags&protocol.FlagKeyframe != 0,
+		HasSPSPPS:  fb.flags&protocol.FlagSPSPPS != 0,
+		Data:       data,
+	}
+}
+
+func hasStartCode(data []byte) bool {
+	if len(data) >= 4 && data[0] == 0 && data[1] == 0 && data[2] == 0 && data[3] == 1 {
+		return true
+	}
+	if len(data) >= 3 && data[0] == 0 && data[1] == 0 && data[2] == 1 {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,207 @@
+package rtp
+
+import (
+	"time"
+
+	"fpv-sender/h264"
+	"fpv-sender/protocol"
+)
+
+const startCode = "\x00\x00\x00\x01"
+
+// minIDRInterval rate-limits IDR requests triggered by sequence-number
+// gaps, matching reassembler.Config.MinIDRInterval's default -- under
+// sustained loss nearly every packet would otherwise open a new gap and
+// flood the control channel with forced-keyframe requests.
+const minIDRInterval = 200 * time.Millisecond
+
+// Depayloader reassembles RTP packets carrying RFC 6184 H.264 payloads back
+// into h264.AccessUnit values, tracking SequenceNumber to detect loss --
+// RTP carries no equivalent of VideoFragment's FragCount/FragIndex, so a
+// missed packet is only visible as a gap in this counter.
+type Depayloader struct {
+	onIDRRequest   func(reason uint8)
+	lastIDRReqAt   time.Time
+	haveLastIDRReq bool
+
+	haveSeq     bool
+	expectedSeq uint16
+
+	auBuf    []byte
+	nals     []h264.NALUnit
+	fuBuf    []byte
+	fuType   uint8
+	fuActive bool
+}
+
+// NewDepayloader creates a Depayloader. onIDRRequest is invoked (with the
+// request reason to use) whenever a sequence-number gap forces the
+// in-progress access unit to be discarded; the caller is expected to
+// actually send the protocol.IDRRequest packet, the same contract as
+// reassembler.New's onIDRRequest.
+func NewDepayloader(onIDRRequest func(reason uint8)) *Depayloader {
+	return &Depayloader{onIDRRequest: onIDRRequest}
+}
+
+// Push feeds one RTP packet into the depacketizer. It returns a completed
+// AccessUnit once the packet carrying the RTP marker bit (end of frame) has
+// been processed, or nil if the frame is still being assembled.
+func (d *Depayloader) Push(packet []byte) (*h264.AccessUnit, error) {
+	var h Header
+	payloadOffset, err := h.Unmarshal(packet)
+	if err != nil {
+		return nil, err
+	}
+	payload := packet[payloadOffset:]
+	if len(payload) == 0 {
+		return nil, ErrShortPacket
+	}
+
+	if d.haveSeq {
+		switch delta := int16(h.SequenceNumber - d.expectedSeq); {
+		case delta == 0:
+			// In order -- the common case.
+		case delta < 0:
+			// At or before the last packet we already consumed: a stale
+			// retransmission or (sender.PolicyDuplicate bonding the same
+			// fragment across paths) a redundant copy that already arrived
+			// from a faster path. Nothing was lost, so just drop this copy
+			// without touching whatever's mid-assembly.
+			return nil, nil
+		default:
+			// A true gap: at least one packet between the last one we saw
+			// and this one never arrived, so whatever NAL (or FU-A fragment)
+			// was mid-flight is now corrupt. Discard the in-progress access
+			// unit and ask for a fresh keyframe rather than handing a
+			// broken one to the decoder.
+			d.auBuf = nil
+			d.nals = nil
+			d.fuBuf = nil
+			d.fuActive = false
+			d.requestIDR()
+		}
+	}
+	d.haveSeq = true
+	d.expectedSeq = h.SequenceNumber + 1
+
+	nalType := payload[0] & 0x1F
+
+	switch {
+	case nalType == naluTypeSTAPA:
+		if err := d.pushSTAPA(payload); err != nil {
+			return nil, err
+		}
+	case nalType == naluTypeFUA:
+		if err := d.pushFUA(payload); err != nil {
+			return nil, err
+		}
+	default:
+		d.appendNAL(payload)
+	}
+
+	if h.Marker {
+		return d.flush(), nil
+	}
+	return nil, nil
+}
+
+// pushSTAPA unpacks a STAP-A (RFC 6184 §5.7.1) into its member NALs.
+func (d *Depayloader) pushSTAPA(payload []byte) error {
+	offset := 1 // skip the STAP-A aggregate header byte
+	for offset+2 <= len(payload) {
+		size := int(payload[offset])<<8 | int(payload[offset+1])
+		offset += 2
+		if offset+size > len(payload) {
+			return ErrShortPacket
+		}
+		d.appendNAL(payload[offset : offset+size])
+		offset += size
+	}
+	return nil
+}
+
+// pushFUA reassembles a fragmented NAL from FU-A packets (RFC 6184 §5.8).
+func (d *Depayloader) pushFUA(payload []byte) error {
+	if len(payload) < fuHeaderSize {
+		return ErrShortPacket
+	}
+	fnri := payload[0] & 0xE0
+	fuHeader := payload[1]
+	start := fuHeader&0x80 != 0
+	end := fuHeader&0x40 != 0
+	nalType := fuHeader & 0x1F
+
+	if start {
+		d.fuBuf = d.fuBuf[:0]
+		d.fuBuf = append(d.fuBuf, fnri|nalType)
+		d.fuType = nalType
+		d.fuActive = true
+	}
+	if !d.fuActive {
+		// Missed the start fragment; drop the rest of this NAL.
+		return nil
+	}
+	d.fuBuf = append(d.fuBuf, payload[fuHeaderSize:]...)
+
+	if end {
+		d.appendNAL(d.fuBuf)
+		d.fuActive = false
+	}
+	return nil
+}
+
+// appendNAL records a raw NAL (header byte + RBSP, no start code) into the
+// access unit currently being assembled.
+func (d *Depayloader) appendNAL(raw []byte) {
+	if len(raw) == 0 {
+		return
+	}
+	data := make([]byte, 0, len(startCode)+len(raw))
+	data = append(data, startCode...)
+	data = append(data, raw...)
+
+	nal := h264.NALUnit{
+		Type:   raw[0] & 0x1F,
+		RefIDC: (raw[0] >> 5) & 0x03,
+		Data:   data,
+	}
+	d.nals = append(d.nals, nal)
+	d.auBuf = append(d.auBuf, data...)
+}
+
+// flush finalizes the access unit accumulated so far and resets state for
+// the next one.
+func (d *Depayloader) flush() *h264.AccessUnit {
+	au := &h264.AccessUnit{
+		NALs: d.nals,
+		Data: d.auBuf,
+	}
+	for i := range au.NALs {
+		if au.NALs[i].IsKeyframe() {
+			au.IsKeyframe = true
+		}
+		if au.NALs[i].IsSPS() || au.NALs[i].IsPPS() {
+			au.HasSPSPPS = true
+		}
+	}
+
+	d.nals = nil
+	d.auBuf = nil
+	return au
+}
+
+// requestIDR invokes onIDRRequest, rate-limited to at most one call per
+// minIDRInterval -- sustained loss would otherwise reopen a gap on nearly
+// every packet.
+func (d *Depayloader) requestIDR() {
+	now := time.Now()
+	if d.haveLastIDRReq && now.Sub(d.lastIDRReqAt) < minIDRInterval {
+		return
+	}
+	d.lastIDRReqAt = now
+	d.haveLastIDRReq = true
+
+	if d.onIDRRequest != nil {
+		d.onIDRRequest(protocol.IDRReasonLoss)
+	}
+}
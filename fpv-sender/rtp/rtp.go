@@ -0,0 +1,314 @@
+// Package rtp implements RFC 6184 RTP payloadization for H.264, so the Pi
+// transmitter can interoperate with standard WebRTC/gstreamer/ffmpeg peers
+// instead of only speaking the custom MsgTypeVideoFragment framing.
+package rtp
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"fpv-sender/h264"
+)
+
+// ClockRate is the RTP clock rate used for H.264 (RFC 6184 §5.1).
+const ClockRate = 90000
+
+// HeaderSize is the size of a fixed RTP header with no CSRC or extensions.
+const HeaderSize = 12
+
+// NAL unit types used by the RTP packetization (RFC 6184 §5.2, §5.7, §5.8).
+const (
+	naluTypeSTAPA = 24
+	naluTypeFUA   = 28
+)
+
+// fuHeaderSize is the FU indicator + FU header byte pair (RFC 6184 §5.8).
+const fuHeaderSize = 2
+
+// Errors
+var (
+	ErrBufferTooSmall = errors.New("rtp: buffer too small")
+	ErrShortPacket    = errors.New("rtp: packet too short")
+	ErrMTUTooSmall    = errors.New("rtp: MTU too small to fragment NAL")
+)
+
+// Header is a fixed (no CSRC, no extensions) RTP header.
+type Header struct {
+	Version        uint8
+	Padding        bool
+	Marker         bool
+	PayloadType    uint8
+	SequenceNumber uint16
+	Timestamp      uint32
+	SSRC           uint32
+}
+
+// Marshal writes the RTP header to buf (must be >= HeaderSize).
+func (h *Header) Marshal(buf []byte) (int, error) {
+	if len(buf) < HeaderSize {
+		return 0, ErrBufferTooSmall
+	}
+	buf[0] = (2 << 6) // version 2, no padding/extension/CSRC
+	if h.Padding {
+		buf[0] |= 1 << 5
+	}
+	buf[1] = h.PayloadType & 0x7F
+	if h.Marker {
+		buf[1] |= 1 << 7
+	}
+	binary.BigEndian.PutUint16(buf[2:4], h.SequenceNumber)
+	binary.BigEndian.PutUint32(buf[4:8], h.Timestamp)
+	binary.BigEndian.PutUint32(buf[8:12], h.SSRC)
+	return HeaderSize, nil
+}
+
+// Unmarshal reads a fixed RTP header from buf. CSRC entries and extensions,
+// if present, are skipped and not exposed.
+func (h *Header) Unmarshal(buf []byte) (payloadOffset int, err error) {
+	if len(buf) < HeaderSize {
+		return 0, ErrShortPacket
+	}
+	h.Version = buf[0] >> 6
+	h.Padding = buf[0]&0x20 != 0
+	extension := buf[0]&0x10 != 0
+	csrcCount := int(buf[0] & 0x0F)
+	h.Marker = buf[1]&0x80 != 0
+	h.PayloadType = buf[1] & 0x7F
+	h.SequenceNumber = binary.BigEndian.Uint16(buf[2:4])
+	h.Timestamp = binary.BigEndian.Uint32(buf[4:8])
+	h.SSRC = binary.BigEndian.Uint32(buf[8:12])
+
+	offset := HeaderSize + csrcCount*4
+	if len(buf) < offset {
+		return 0, ErrShortPacket
+	}
+	if extension {
+		if len(buf) < offset+4 {
+			return 0, ErrShortPacket
+		}
+		extLen := int(binary.BigEndian.Uint16(buf[offset+2:offset+4])) * 4
+		offset += 4 + extLen
+		if len(buf) < offset {
+			return 0, ErrShortPacket
+		}
+	}
+	return offset, nil
+}
+
+// Payloader turns h264.AccessUnit values into RTP packets per RFC 6184.
+type Payloader struct {
+	PayloadType uint8
+	SSRC        uint32
+	MTU         int // max size of a full RTP packet, header included
+
+	seq uint16
+}
+
+// NewPayloader creates a Payloader with the given SSRC and payload type.
+// mtu is the maximum RTP packet size (header + payload); callers typically
+// pass protocol.MaxPayloadSize.
+func NewPayloader(ssrc uint32, payloadType uint8, mtu int) *Payloader {
+	return &Payloader{PayloadType: payloadType, SSRC: ssrc, MTU: mtu}
+}
+
+// Payload fragments/aggregates an Access Unit into a series of RTP packets.
+// tsMs is the AU's Pi-local presentation time in milliseconds, converted to
+// the 90kHz RTP clock.
+func (p *Payloader) Payload(au *h264.AccessUnit, tsMs uint32) ([][]byte, error) {
+	if p.MTU <= HeaderSize+fuHeaderSize+1 {
+		return nil, ErrMTUTooSmall
+	}
+	maxPayload := p.MTU - HeaderSize
+	timestamp := tsMs * (ClockRate / 1000)
+
+	var packets [][]byte
+
+	// group holds consecutive small NALs pending aggregation into a STAP-A.
+	var group [][]byte
+	groupSize := 0 // bytes a STAP-A made from `group` would occupy, excluding RTP header
+
+	flushGroup := func(isLast bool) error {
+		if len(group) == 0 {
+			return nil
+		}
+		if len(group) == 1 {
+			pkt, err := p.singleNALUPacket(group[0], timestamp, isLast)
+			if err != nil {
+				return err
+			}
+			packets = append(packets, pkt)
+		} else {
+			pkt, err := p.stapAPacket(group, timestamp, isLast)
+			if err != nil {
+				return err
+			}
+			packets = append(packets, pkt)
+		}
+		group = nil
+		groupSize = 0
+		return nil
+	}
+
+	for i := range au.NALs {
+		raw := stripStartCode(au.NALs[i].Data)
+		if len(raw) == 0 {
+			continue
+		}
+		isLastNAL := i == len(au.NALs)-1
+
+		// A NAL that doesn't fit even alone must be fragmented with FU-A.
+		if len(raw) > maxPayload {
+			if err := flushGroup(false); err != nil {
+				return nil, err
+			}
+			fragPackets, err := p.fragmentFUA(raw, timestamp, isLastNAL)
+			if err != nil {
+				return nil, err
+			}
+			packets = append(packets, fragPackets...)
+			continue
+		}
+
+		// STAP-A entries are length-prefixed (2 bytes) plus the NAL bytes,
+		// and the aggregate also needs its own 1-byte STAP-A header.
+		entrySize := 2 + len(raw)
+		overhead := 0
+		if len(group) == 0 {
+			overhead = 1 // STAP-A header byte, only once
+		}
+		if groupSize+entrySize+overhead > maxPayload {
+			if err := flushGroup(false); err != nil {
+				return nil, err
+			}
+		}
+		if len(group) == 0 {
+			groupSize = 1
+		}
+		group = append(group, raw)
+		groupSize += entrySize
+
+		if isLastNAL {
+			if err := flushGroup(true); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return packets, nil
+}
+
+func (p *Payloader) nextSeq() uint16 {
+	seq := p.seq
+	p.seq++
+	return seq
+}
+
+func (p *Payloader) header(timestamp uint32, marker bool) Header {
+	return Header{
+		Version:        2,
+		Marker:         marker,
+		PayloadType:    p.PayloadType,
+		SequenceNumber: p.nextSeq(),
+		Timestamp:      timestamp,
+		SSRC:           p.SSRC,
+	}
+}
+
+// singleNALUPacket builds a Single NAL Unit Packet (RFC 6184 §5.6): the RTP
+// payload is simply the NAL header byte followed by the RBSP.
+func (p *Payloader) singleNALUPacket(raw []byte, timestamp uint32, marker bool) ([]byte, error) {
+	buf := make([]byte, HeaderSize+len(raw))
+	h := p.header(timestamp, marker)
+	if _, err := h.Marshal(buf); err != nil {
+		return nil, err
+	}
+	copy(buf[HeaderSize:], raw)
+	return buf, nil
+}
+
+// stapAPacket aggregates multiple small NALs into one STAP-A packet
+// (RFC 6184 §5.7.1). The synthesized aggregate header keeps the maximum
+// nal_ref_idc of the member NALs and uses type 24 (STAP-A).
+func (p *Payloader) stapAPacket(raws [][]byte, timestamp uint32, marker bool) ([]byte, error) {
+	size := HeaderSize + 1
+	maxNRI := uint8(0)
+	for _, raw := range raws {
+		size += 2 + len(raw)
+		if nri := (raw[0] >> 5) & 0x03; nri > maxNRI {
+			maxNRI = nri
+		}
+	}
+
+	buf := make([]byte, size)
+	h := p.header(timestamp, marker)
+	if _, err := h.Marshal(buf); err != nil {
+		return nil, err
+	}
+
+	buf[HeaderSize] = (maxNRI << 5) | naluTypeSTAPA
+	offset := HeaderSize + 1
+	for _, raw := range raws {
+		binary.BigEndian.PutUint16(buf[offset:offset+2], uint16(len(raw)))
+		offset += 2
+		copy(buf[offset:], raw)
+		offset += len(raw)
+	}
+	return buf, nil
+}
+
+// fragmentFUA splits a NAL exceeding the MTU into FU-A fragments
+// (RFC 6184 §5.8). Each fragment carries a 2-byte header: byte 1 preserves
+// F/NRI of the original NAL with type set to FU-A, byte 2 encodes the
+// Start/End bits plus the original NAL type.
+func (p *Payloader) fragmentFUA(raw []byte, timestamp uint32, isLastNAL bool) ([][]byte, error) {
+	fnri := raw[0] & 0xE0 // forbidden_zero_bit + nal_ref_idc
+	nalType := raw[0] & 0x1F
+	payload := raw[1:]
+
+	maxChunk := p.MTU - HeaderSize - fuHeaderSize
+	if maxChunk <= 0 {
+		return nil, ErrMTUTooSmall
+	}
+
+	var packets [][]byte
+	for start := 0; start < len(payload); start += maxChunk {
+		end := start + maxChunk
+		if end > len(payload) {
+			end = len(payload)
+		}
+		isFirst := start == 0
+		isLast := end == len(payload)
+
+		fuHeader := nalType
+		if isFirst {
+			fuHeader |= 1 << 7 // S bit
+		}
+		if isLast {
+			fuHeader |= 1 << 6 // E bit
+		}
+
+		chunk := payload[start:end]
+		buf := make([]byte, HeaderSize+fuHeaderSize+len(chunk))
+		h := p.header(timestamp, isLast && isLastNAL)
+		if _, err := h.Marshal(buf); err != nil {
+			return nil, err
+		}
+		buf[HeaderSize] = fnri | naluTypeFUA
+		buf[HeaderSize+1] = fuHeader
+		copy(buf[HeaderSize+fuHeaderSize:], chunk)
+		packets = append(packets, buf)
+	}
+	return packets, nil
+}
+
+// stripStartCode removes the Annex B start code, leaving the NAL header byte
+// followed by the RBSP payload.
+func stripStartCode(data []byte) []byte {
+	if len(data) >= 4 && data[0] == 0 && data[1] == 0 && data[2] == 0 && data[3] == 1 {
+		return data[4:]
+	}
+	if len(data) >= 3 && data[0] == 0 && data[1] == 0 && data[2] == 1 {
+		return data[3:]
+	}
+	return data
+}
@@ -0,0 +1,283 @@
+// Package cc implements a lightweight send-side congestion controller for
+// the video uplink, patterned after BBR's bandwidth-and-minRTT model with
+// GCC's (WebRTC's Google Congestion Control) loss-based backoff layered on
+// top, scaled down to what this sender actually needs: a pacing rate for
+// Packetizer's token bucket and a target bitrate to hand the encoder,
+// instead of the fixed bitrate and no feedback loop this replaces.
+package cc
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// state is the controller's BBR-style growth phase. The loss response in
+// OnReport applies regardless of state.
+type state int
+
+const (
+	stateStartup state = iota
+	stateProbeBW
+)
+
+const (
+	// startupGain is how much the bandwidth estimate grows each report
+	// while in stateStartup, the same doubling-every-couple-RTTs idea as
+	// BBR's startup, until a report shows delivery isn't keeping up.
+	startupGain = 1.25
+
+	// startupPlateauRatio: if a report's observed delivery rate falls this
+	// far short of what we just tried to push, startup has found the
+	// ceiling and hands off to stateProbeBW at the observed rate.
+	startupPlateauRatio = 0.75
+
+	// lossThreshold is the reported-loss fraction above which OnReport
+	// treats the link as congested and backs the pacing rate off
+	// multiplicatively -- GCC's loss controller, not BBR's (BBR mostly
+	// ignores loss; on a single bonded LTE/Wi-Fi uplink with no other
+	// competing flows, reacting to loss directly is simpler and safer).
+	lossThreshold  = 0.1
+	decreaseFactor = 0.85
+
+	// backlogFrames is how many Access Units' worth of gap between our
+	// current frame and the receiver's last-acked one (highestFrameID)
+	// counts as "in flight" congestion -- a backlog growing this large
+	// means frames are queuing somewhere on the path, not just a lossy
+	// sample, so it gets the same multiplicative backoff as loss.
+	backlogFrames = 30
+
+	// probeCycleRTTs is how many minRTT-lengths stateProbeBW spends on
+	// each phase of probeCycleGains, BBR's 8-phase bandwidth probe cycle
+	// scaled down to 2 phases: briefly probe high, then settle back.
+	probeCycleRTTs = 4
+
+	minPacingRateBps = 250_000   // 250 kbps floor -- enough for a very degraded link to still say something
+	maxPacingRateBps = 8_000_000 // 8 Mbps ceiling -- comfortably above this rig's sensor/encoder ceiling
+
+	// initialPacingRateBps seeds the estimate at roughly the old fixed
+	// Bitrate default (main.go) plus headroom for protocol/FEC overhead,
+	// so slow-start has a sane starting point instead of probing from 0.
+	initialPacingRateBps = 1_800_000
+
+	// bitrateMargin is how far under the pacing rate the target encoder
+	// bitrate sits, leaving room for protocol/FEC overhead and pacing
+	// jitter so the encoder's own output doesn't immediately outrun it.
+	bitrateMargin = 0.85
+
+	// burstWindow bounds the token bucket's burst allowance to this many
+	// seconds of the current pacing rate, so a long idle gap (e.g. between
+	// Access Units) can't let tokens build up into a multi-second burst.
+	burstWindow = 0.2
+
+	// restartHysteresis/restartDeltaThreshold gate how often and how far
+	// the target bitrate has to move before ShouldRestartCapture tells
+	// the caller to actually restart the encoder -- restarting is
+	// disruptive (a brief gap, then a fresh IDR), so it's worth doing
+	// only for a real, sustained change.
+	restartHysteresis     = 5 * time.Second
+	restartDeltaThreshold = 0.2
+)
+
+// probeCycleGains is stateProbeBW's gain cycle: spend probeCycleRTTs RTTs
+// pushing above the current estimate to see if more bandwidth is there,
+// then an equal stretch at a slightly lower gain to drain whatever queue
+// that probe built up, then the two phases repeat. There is no separate
+// steady (gain 1) phase.
+var probeCycleGains = [2]float64{1.25, 0.85}
+
+// Controller estimates available bandwidth and minimum RTT from periodic
+// receiver reports (protocol.Keepalive's BytesReceived/PacketsLost/
+// HighestFrameID, decoded by the caller) and turns that into a pacing rate
+// for Reserve and a target encoder bitrate for TargetBitrateBps. All
+// methods are safe for concurrent use: reports arrive off the app's
+// receive loop while Reserve is called from whatever goroutine is
+// packetizing.
+type Controller struct {
+	mu sync.Mutex
+
+	st            state
+	pacingRateBps float64
+	minRTTMs      float64
+	haveRTT       bool
+
+	probeCycleStart time.Time
+	probeRTTMs      float64 // minRTTMs snapshotted when the current probe cycle started
+
+	lastRestartRec time.Time
+
+	tokens     float64
+	lastRefill time.Time
+}
+
+// NewController returns a Controller seeded at initialPacingRateBps and in
+// slow-start.
+func NewController() *Controller {
+	now := time.Now()
+	return &Controller{
+		st:              stateStartup,
+		pacingRateBps:   initialPacingRateBps,
+		probeCycleStart: now,
+		lastRefill:      now,
+	}
+}
+
+// OnReport folds in one receiver report. rttMs is this report's RTT sample
+// (the caller computes it from Keepalive.EchoTsMs the same way
+// sender.MultiPath's readLoop does). bytesReceived and windowMs are
+// the Keepalive's byte counter and the elapsed time since the previous
+// report, together giving an observed delivery rate. lossFraction is
+// packetsLost from the same report divided by however many fragments the
+// caller sent over that window. frameBacklog is the gap between the
+// current frame being encoded and the receiver's last-acked
+// highestFrameID.
+func (c *Controller) OnReport(rttMs float64, bytesReceived uint32, windowMs float64, lossFraction float64, frameBacklog int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if rttMs >= 0 && (!c.haveRTT || rttMs < c.minRTTMs) {
+		c.minRTTMs = rttMs
+		c.haveRTT = true
+	}
+
+	var deliveryRateBps float64
+	if windowMs > 0 {
+		deliveryRateBps = float64(bytesReceived) * 8 / (windowMs / 1000)
+	}
+
+	switch c.st {
+	case stateStartup:
+		next := c.pacingRateBps * startupGain
+		if deliveryRateBps > 0 && deliveryRateBps < next*startupPlateauRatio {
+			// Pushed harder than the link actually delivered: we've found
+			// the ceiling, so settle at what was actually observed instead
+			// of the still-growing target.
+			c.pacingRateBps = deliveryRateBps
+			c.enterProbeBW()
+		} else {
+			c.pacingRateBps = next
+		}
+	case stateProbeBW:
+		if deliveryRateBps > 0 {
+			c.pacingRateBps = deliveryRateBps * c.probeGain()
+		}
+	}
+
+	congested := lossFraction > lossThreshold || frameBacklog > backlogFrames
+	if congested {
+		c.pacingRateBps *= decreaseFactor
+		if c.st == stateStartup {
+			c.enterProbeBW()
+		}
+	}
+
+	if c.pacingRateBps < minPacingRateBps {
+		c.pacingRateBps = minPacingRateBps
+	}
+	if c.pacingRateBps > maxPacingRateBps {
+		c.pacingRateBps = maxPacingRateBps
+	}
+}
+
+// enterProbeBW must be called with mu held.
+func (c *Controller) enterProbeBW() {
+	c.st = stateProbeBW
+	c.probeCycleStart = time.Now()
+	c.probeRTTMs = c.minRTTMs
+}
+
+// probeGain returns stateProbeBW's current cycle gain. Must be called with
+// mu held.
+func (c *Controller) probeGain() float64 {
+	rtt := c.probeRTTMs
+	if rtt <= 0 {
+		rtt = 50 // no RTT sample yet -- assume a conservative 50ms so the cycle still advances
+	}
+	phaseMs := rtt * probeCycleRTTs
+	if phaseMs <= 0 {
+		return 1
+	}
+	elapsed := time.Since(c.probeCycleStart).Seconds() * 1000
+	phase := int(elapsed/phaseMs) % len(probeCycleGains)
+	return probeCycleGains[phase]
+}
+
+// PacingRateBps returns the current pacing rate estimate in bits/sec.
+func (c *Controller) PacingRateBps() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pacingRateBps
+}
+
+// TargetBitrateBps returns the encoder bitrate (bits/sec) the current
+// pacing rate estimate supports, leaving bitrateMargin headroom for
+// protocol/FEC overhead.
+func (c *Controller) TargetBitrateBps() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return uint32(c.pacingRateBps * bitrateMargin)
+}
+
+// MinRTTMs returns the lowest RTT sample observed so far, or 0 if none has
+// arrived yet.
+func (c *Controller) MinRTTMs() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.minRTTMs
+}
+
+// Reserve is Packetizer's token bucket: it accounts for n bytes about to go
+// out and returns how long the caller should wait first (0 if the bucket
+// already has enough tokens) to hold the batch to the current pacing rate,
+// replacing the old fixed-200µs-per-packet sleep with a rate that tracks
+// the link.
+func (c *Controller) Reserve(n int) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(c.lastRefill).Seconds()
+	c.lastRefill = now
+
+	rateBps := c.pacingRateBps / 8
+	c.tokens += elapsed * rateBps
+	if burst := rateBps * burstWindow; c.tokens > burst {
+		c.tokens = burst
+	}
+
+	c.tokens -= float64(n)
+	if c.tokens >= 0 {
+		return 0
+	}
+
+	deficit := -c.tokens
+	c.tokens = 0
+	if rateBps <= 0 {
+		return 0
+	}
+	return time.Duration(deficit / rateBps * float64(time.Second))
+}
+
+// ShouldRestartCapture reports whether the target bitrate has drifted far
+// enough from currentBps, and long enough since the last recommendation,
+// to justify the disruption of restarting the encoder at a new bitrate.
+// Returns the new target and true when it has.
+func (c *Controller) ShouldRestartCapture(currentBps uint32) (uint32, bool) {
+	c.mu.Lock()
+	target := uint32(c.pacingRateBps * bitrateMargin)
+	now := time.Now()
+	sinceLast := now.Sub(c.lastRestartRec)
+	c.mu.Unlock()
+
+	if sinceLast < restartHysteresis || currentBps == 0 {
+		return 0, false
+	}
+	if math.Abs(float64(target)-float64(currentBps))/float64(currentBps) < restartDeltaThreshold {
+		return 0, false
+	}
+
+	c.mu.Lock()
+	c.lastRestartRec = now
+	c.mu.Unlock()
+	return target, true
+}
@@ -0,0 +1,451 @@
+package sender
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"fpv-sender/protocol"
+	"fpv-sender/transport"
+)
+
+// Policy selects how MultiPath schedules fragments across its Paths.
+type Policy int
+
+const (
+	// PolicyWeighted round-robins across paths weighted by a smoothed
+	// RTT/loss score -- the default, for maximizing throughput across
+	// bonded links (e.g. LTE + Wi-Fi). The unit scheduled is a whole
+	// SendBatch call (one Access Unit's fragments), not each fragment: all
+	// fragments of a batch go out on the same path.
+	PolicyWeighted Policy = iota
+	// PolicyDuplicate sends every fragment down every path -- bandwidth for
+	// latency/reliability, meant for traffic that can't tolerate a dropped
+	// link, such as keyframes.
+	PolicyDuplicate
+)
+
+// rttAlpha/lossAlpha smooth a Path's RTT/loss estimate the same way
+// reassembler.go's JitterEstimateMs is smoothed: fast enough to track a
+// path degrading, slow enough not to chase one outlier keepalive.
+const (
+	rttAlpha  = 0.2
+	lossAlpha = 0.1
+)
+
+// pathKeepaliveInterval is how often MultiPath probes each Path on its own,
+// independent of the app-level Sender.SendKeepalive cadence, purely to keep
+// PolicyWeighted's RTT/loss estimate fresh.
+const pathKeepaliveInterval = time.Second
+
+// Path is one physical link a MultiPath schedules fragments across --
+// typically bound to a specific interface via SO_BINDTODEVICE (e.g. eth0,
+// wlan0, wwan0) using transport.NewUDPBindOnDevice.
+type Path struct {
+	ID   uint8
+	Name string
+	Bind transport.Bind
+
+	mu               sync.Mutex
+	endpoint         transport.Endpoint // set via SetEndpoint once a peer is known; read by this Path's own background goroutines
+	rttMs            float64
+	haveRTT          bool
+	lossRate         float64
+	pendingSinceTick bool
+
+	// current is PolicyWeighted's smooth-weighted-round-robin credit. It's
+	// only ever touched by the MultiPath that owns this Path, under that
+	// MultiPath's mu -- not this Path's own mu, which guards endpoint and
+	// the RTT/loss stats a receive-loop goroutine updates concurrently.
+	current float64
+}
+
+// SetEndpoint sets the peer address this path sends to, e.g. once a
+// --local target or hole-punched peer is known. Safe to call concurrently
+// with this Path's own background keepalive/receive goroutines.
+func (p *Path) SetEndpoint(ep transport.Endpoint) {
+	p.mu.Lock()
+	p.endpoint = ep
+	p.mu.Unlock()
+}
+
+func (p *Path) endpointSnapshot() transport.Endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.endpoint
+}
+
+// Tick marks that MultiPath is about to send this path's own internal
+// keepalive probe, so weight can tell a path that's gone silent (no echo
+// since the last Tick) from one that's merely quiet between samples.
+func (p *Path) Tick() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sample := 0.0
+	if p.pendingSinceTick {
+		sample = 1.0
+	}
+	p.lossRate = lossAlpha*sample + (1-lossAlpha)*p.lossRate
+	p.pendingSinceTick = true
+}
+
+// recordEcho folds in an RTT sample from a keepalive echo arriving back on
+// this path, and clears the pending-loss flag Tick set.
+func (p *Path) recordEcho(rttMs float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pendingSinceTick = false
+	if !p.haveRTT {
+		p.rttMs = rttMs
+		p.haveRTT = true
+		return
+	}
+	p.rttMs = rttAlpha*rttMs + (1-rttAlpha)*p.rttMs
+}
+
+// weight returns this path's PolicyWeighted scheduling weight -- higher is
+// better. A path with no RTT sample yet (just added, or its first keepalive
+// hasn't echoed back) gets a weight of 1, the same as the best score a
+// measured path can reach (RTT at the 1ms floor, no loss), so it is
+// favoured until it has stats.
+func (p *Path) weight() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.haveRTT {
+		return 1
+	}
+	rtt := p.rttMs
+	if rtt < 1 {
+		rtt = 1
+	}
+	return 1 / (rtt * (1 + p.lossRate))
+}
+
+// pathRecv is one inbound packet (or error) a Path's read loop hands off to
+// MultiPath's merged ReceiveIPv4/ReceiveIPv6.
+type pathRecv struct {
+	n   int
+	buf []byte
+	ep  transport.Endpoint
+	err error
+}
+
+// MultiPath bonds N Paths -- e.g. one per physical interface -- and
+// schedules video fragments across them per Policy, the way the mpbl3p
+// multipath proxy bonds several uplinks into a single flow. A path dropping
+// (an LTE modem losing signal) degrades throughput instead of killing the
+// stream. MultiPath implements transport.Bind, so it's a drop-in bind for
+// Sender: NewSenderWithConfig(multiPath, transport.Endpoint{}, sessionID, cfg)
+// works unchanged -- the Endpoint argument Sender threads through Send/
+// SendBatch is ignored since every Path already carries its own destination.
+type MultiPath struct {
+	paths      []*Path
+	policy     Policy
+	sessionID  uint32
+	wireFormat WireFormat
+	startTime  time.Time
+
+	mu       sync.Mutex
+	deadline time.Time
+
+	v4ch   chan pathRecv
+	v6ch   chan pathRecv
+	closed chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup
+}
+
+// NewMultiPath bonds paths under policy and starts their receive loops and
+// internal per-path keepalive probes. wireFormat must match the Sender this
+// MultiPath will back: the path_id byte SendBatch stamps only exists in
+// VideoFragment framing (WireFormatFragment), not in raw RFC 6184 RTP
+// packets, so WireFormatRTP batches are scheduled across paths unstamped.
+func NewMultiPath(paths []*Path, policy Policy, sessionID uint32, wireFormat WireFormat) *MultiPath {
+	m := &MultiPath{
+		paths:      paths,
+		policy:     policy,
+		sessionID:  sessionID,
+		wireFormat: wireFormat,
+		startTime:  time.Now(),
+		v4ch:       make(chan pathRecv, len(paths)),
+		v6ch:       make(chan pathRecv, len(paths)),
+		closed:     make(chan struct{}),
+	}
+	for _, p := range paths {
+		m.wg.Add(1)
+		go m.readLoop(p, p.Bind.ReceiveIPv4, m.v4ch)
+		if p.Bind.HasIPv6() {
+			m.wg.Add(1)
+			go m.readLoop(p, p.Bind.ReceiveIPv6, m.v6ch)
+		}
+		m.wg.Add(1)
+		go m.pathKeepaliveLoop(p)
+	}
+	return m
+}
+
+func (m *MultiPath) elapsedMs() uint32 {
+	return uint32(time.Since(m.startTime).Milliseconds())
+}
+
+// pathKeepaliveLoop sends a protocol.Keepalive on p every
+// pathKeepaliveInterval purely to sample p's RTT/loss for PolicyWeighted --
+// independent of the app-level keepalive Sender.SendKeepalive already sends
+// (which rides whichever path Send picks as primary). The sample is biased
+// by the gap between this clock's start and the Sender's packetizer clock's
+// start, but that gap is a few lines of startup code wide -- negligible,
+// and since every path is sampled against the same clock here it cancels
+// out of the comparison PolicyWeighted actually cares about.
+func (m *MultiPath) pathKeepaliveLoop(p *Path) {
+	defer m.wg.Done()
+	ticker := time.NewTicker(pathKeepaliveInterval)
+	defer ticker.Stop()
+
+	var seq uint32
+	buf := make([]byte, protocol.KeepaliveHeaderSize)
+	for {
+		select {
+		case <-m.closed:
+			return
+		case <-ticker.C:
+			seq++
+			p.Tick()
+			ka := protocol.Keepalive{SessionID: m.sessionID, TsMs: m.elapsedMs(), Seq: seq}
+			if n, err := ka.Marshal(buf); err == nil {
+				_ = p.Bind.Send(buf[:n], p.endpointSnapshot())
+			}
+		}
+	}
+}
+
+// readLoop mirrors the App.receiveFamily pattern (a short read deadline in
+// a loop) over one Path's Bind, folding any Keepalive echo meant for this
+// path into p's RTT/loss estimate before forwarding the packet on to ch for
+// MultiPath's own ReceiveIPv4/ReceiveIPv6 callers.
+func (m *MultiPath) readLoop(p *Path, recv func([]byte) (int, transport.Endpoint, error), ch chan<- pathRecv) {
+	defer m.wg.Done()
+	for {
+		select {
+		case <-m.closed:
+			return
+		default:
+		}
+
+		p.Bind.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		buf := make([]byte, 1500)
+		n, ep, err := recv(buf)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+			select {
+			case ch <- pathRecv{err: err}:
+			case <-m.closed:
+				return
+			}
+			continue
+		}
+
+		if n >= 1 && buf[0] == protocol.MsgTypeKeepalive {
+			var k protocol.Keepalive
+			if err := k.Unmarshal(buf[:n]); err == nil && k.SessionID == m.sessionID && k.EchoTsMs != 0 {
+				if rtt := float64(m.elapsedMs()) - float64(k.EchoTsMs); rtt >= 0 {
+					p.recordEcho(rtt)
+				}
+			}
+		}
+
+		select {
+		case ch <- pathRecv{n: n, buf: buf, ep: ep}:
+		case <-m.closed:
+			return
+		}
+	}
+}
+
+func (m *MultiPath) ReceiveIPv4(buf []byte) (int, transport.Endpoint, error) {
+	return m.receive(m.v4ch, buf)
+}
+
+func (m *MultiPath) ReceiveIPv6(buf []byte) (int, transport.Endpoint, error) {
+	return m.receive(m.v6ch, buf)
+}
+
+func (m *MultiPath) receive(ch <-chan pathRecv, buf []byte) (int, transport.Endpoint, error) {
+	m.mu.Lock()
+	deadline := m.deadline
+	m.mu.Unlock()
+
+	var timeoutCh <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return 0, transport.Endpoint{}, errReceiveTimeout
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return 0, transport.Endpoint{}, r.err
+		}
+		return copy(buf, r.buf[:r.n]), r.ep, nil
+	case <-timeoutCh:
+		return 0, transport.Endpoint{}, errReceiveTimeout
+	}
+}
+
+// timeoutError implements net.Error so callers like App.receiveFamily that
+// type-assert for a Timeout() can treat MultiPath's deadline the same way
+// they already treat a single Bind's.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "transport: receive timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var errReceiveTimeout net.Error = timeoutError{}
+
+// Send implements transport.Bind for single-packet control messages
+// (keepalive/probe/hello). It ignores ep -- each Path already knows its own
+// destination -- and uses whichever path currently scores best, so control
+// traffic rides the healthiest link.
+func (m *MultiPath) Send(buf []byte, _ transport.Endpoint) error {
+	p := m.primary()
+	return p.Bind.Send(buf, p.endpointSnapshot())
+}
+
+// primary returns the path with the highest PolicyWeighted weight.
+func (m *MultiPath) primary() *Path {
+	best := m.paths[0]
+	bestWeight := best.weight()
+	for _, p := range m.paths[1:] {
+		if w := p.weight(); w > bestWeight {
+			best, bestWeight = p, w
+		}
+	}
+	return best
+}
+
+// SendBatch implements transport.Bind for a Packetizer's whole
+// Access-Unit batch, scheduling it across Paths per m.policy. It ignores
+// ep for the same reason Send does.
+func (m *MultiPath) SendBatch(bufs [][]byte, _ transport.Endpoint) (int, error) {
+	if len(bufs) == 0 {
+		return 0, nil
+	}
+	if m.policy == PolicyDuplicate {
+		return m.sendDuplicate(bufs)
+	}
+	return m.sendWeighted(bufs)
+}
+
+// sendWeighted picks one path via smooth weighted round-robin (the scheme
+// nginx's upstream balancer uses): each path accrues credit equal to its
+// weight every pick, the highest-credit path wins, and the winner is then
+// docked the total weight -- so a 3x-weight path is picked three times as
+// often as a 1x one, spread evenly rather than in bursts.
+func (m *MultiPath) sendWeighted(bufs [][]byte) (int, error) {
+	m.mu.Lock()
+	var best *Path
+	total := 0.0
+	for _, p := range m.paths {
+		w := p.weight()
+		total += w
+		p.current += w
+		if best == nil || p.current > best.current {
+			best = p
+		}
+	}
+	best.current -= total
+	m.mu.Unlock()
+
+	if m.wireFormat == WireFormatFragment {
+		for _, b := range bufs {
+			protocol.SetPathID(b, best.ID)
+		}
+	}
+	return best.Bind.SendBatch(bufs, best.endpointSnapshot())
+}
+
+// sendDuplicate sends an independent, re-stamped copy of bufs down every
+// path in parallel, so one dropped link can't stall (or lose) the others.
+// It reports the highest per-path sent count as successful as long as at
+// least one path got bufs out at all -- that's the whole point of bonding:
+// a single path failing shouldn't fail the batch.
+func (m *MultiPath) sendDuplicate(bufs [][]byte) (int, error) {
+	type outcome struct {
+		sent int
+		err  error
+	}
+	outcomes := make([]outcome, len(m.paths))
+
+	var wg sync.WaitGroup
+	for i, p := range m.paths {
+		wg.Add(1)
+		go func(i int, p *Path) {
+			defer wg.Done()
+			stamped := make([][]byte, len(bufs))
+			for j, b := range bufs {
+				cp := make([]byte, len(b))
+				copy(cp, b)
+				if m.wireFormat == WireFormatFragment {
+					protocol.SetPathID(cp, p.ID)
+				}
+				stamped[j] = cp
+			}
+			n, err := p.Bind.SendBatch(stamped, p.endpointSnapshot())
+			outcomes[i] = outcome{n, err}
+		}(i, p)
+	}
+	wg.Wait()
+
+	best := 0
+	var firstErr error
+	for _, o := range outcomes {
+		if o.sent > best {
+			best = o.sent
+		}
+		if o.err != nil && firstErr == nil {
+			firstErr = o.err
+		}
+	}
+	if best == 0 {
+		return 0, firstErr
+	}
+	return best, nil
+}
+
+func (m *MultiPath) SetReadDeadline(t time.Time) error {
+	m.mu.Lock()
+	m.deadline = t
+	m.mu.Unlock()
+	return nil
+}
+
+// HasIPv6 reports whether any bonded path's IPv6 socket is usable.
+func (m *MultiPath) HasIPv6() bool {
+	for _, p := range m.paths {
+		if p.Bind.HasIPv6() {
+			return true
+		}
+	}
+	return false
+}
+
+// Close stops every path's read/keepalive loops and closes its Bind.
+func (m *MultiPath) Close() error {
+	m.once.Do(func() { close(m.closed) })
+	m.wg.Wait()
+
+	var firstErr error
+	for _, p := range m.paths {
+		if err := p.Bind.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
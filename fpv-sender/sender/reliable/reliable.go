@@ -0,0 +1,281 @@
+// Package reliable implements a stripped KCP-style ARQ stream multiplexed
+// onto the same UDP socket as video, carried inside VideoFragment framing
+// on protocol.StreamIDReliable instead of opening a second port or falling
+// back to TCP. It gives control-plane traffic -- IDR requests,
+// encoder-parameter changes, telemetry, and eventually the P2P signaling
+// handshake -- in-order, retransmitted delivery over the hole already
+// punched for video.
+//
+// Unlike KCP this has no selective-ack ranges or congestion window of its
+// own: one cumulative ack per received segment and a single RTO, since the
+// control channel's volume is tiny next to video and sender/cc already
+// owns congestion response for the link as a whole.
+package reliable
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"fpv-sender/protocol"
+	"fpv-sender/transport"
+)
+
+const (
+	// maxSegmentPayload is how much of a Write fits in one ReliableSegment,
+	// leaving room for VideoFragment's own header plus this package's.
+	maxSegmentPayload = protocol.MaxPayloadSize - protocol.VideoFragmentHeaderSize - protocol.ReliableSegmentHeaderSize
+
+	// windowSegments bounds recvPending, the receive-side reorder buffer --
+	// a peer that's gone silent on acking can't make this stream buffer
+	// unbounded out-of-order segments. It's also advertised back to the
+	// peer as this side's receive window.
+	windowSegments = 64
+
+	// minRTO/maxRTO bound RetransmitTick's resend interval; rtoRTTFactor is
+	// the multiple of the latest RTT sample UpdateRTT scales it by, loosely
+	// matching TCP/KCP's practice of timing out well past one round trip
+	// rather than right at it.
+	minRTO       = 200 * time.Millisecond
+	maxRTO       = 5 * time.Second
+	rtoRTTFactor = 2.5
+)
+
+// outSeg is one still-unacknowledged segment sitting in Stream's retransmit
+// queue.
+type outSeg struct {
+	seq     uint32
+	payload []byte
+	sentAt  time.Time
+}
+
+// Stream is one reliable control-channel stream between this sender and its
+// peer. All methods are safe for concurrent use: HandleSegment is called
+// from the app's receive loop, RetransmitTick from a periodic ticker, and
+// Write/Read from whatever goroutine owns the control channel.
+type Stream struct {
+	bind      transport.Bind
+	sessionID uint32
+
+	mu       sync.Mutex
+	endpoint transport.Endpoint // set via SetEndpoint once a peer is known; read by RetransmitTick/sendSegment
+
+	sendMu   sync.Mutex
+	nextSeq  uint32
+	outQueue []*outSeg
+	rtoMs    float64
+
+	recvMu      sync.Mutex
+	recvNext    uint32
+	recvPending map[uint32][]byte
+	readBuf     []byte
+	readCond    *sync.Cond
+	closed      bool
+}
+
+// NewStream returns a Stream ready to send to endpoint over bind, seeded at
+// minRTO until the first UpdateRTT call narrows that estimate.
+func NewStream(bind transport.Bind, endpoint transport.Endpoint, sessionID uint32) *Stream {
+	s := &Stream{
+		bind:        bind,
+		endpoint:    endpoint,
+		sessionID:   sessionID,
+		recvPending: make(map[uint32][]byte),
+		rtoMs:       float64(minRTO / time.Millisecond),
+	}
+	s.readCond = sync.NewCond(&s.recvMu)
+	return s
+}
+
+// SetEndpoint updates the peer endpoint (after hole punching), the same
+// pattern sender.Sender.SetEndpoint and sender.Path.SetEndpoint use.
+func (s *Stream) SetEndpoint(ep transport.Endpoint) {
+	s.mu.Lock()
+	s.endpoint = ep
+	s.mu.Unlock()
+}
+
+func (s *Stream) endpointSnapshot() transport.Endpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.endpoint
+}
+
+// UpdateRTT rescales the retransmit timeout from a fresh RTT sample, the
+// same one the caller feeds sender/cc.Controller.OnReport -- this stream
+// has no RTT source of its own.
+func (s *Stream) UpdateRTT(rttMs float64) {
+	if rttMs <= 0 {
+		return
+	}
+	rto := rttMs * rtoRTTFactor
+	if min := float64(minRTO / time.Millisecond); rto < min {
+		rto = min
+	}
+	if max := float64(maxRTO / time.Millisecond); rto > max {
+		rto = max
+	}
+	s.sendMu.Lock()
+	s.rtoMs = rto
+	s.sendMu.Unlock()
+}
+
+// Write queues p for reliable delivery, chunking it across as many
+// segments as needed and sending each once immediately; RetransmitTick
+// resends any that go unacknowledged past the current RTO. Write doesn't
+// block on the peer's window -- outQueue just keeps growing if the peer
+// stops acking, there being no flow-control backpressure yet.
+func (s *Stream) Write(p []byte) (int, error) {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	for off := 0; off < len(p); off += maxSegmentPayload {
+		end := off + maxSegmentPayload
+		if end > len(p) {
+			end = len(p)
+		}
+		chunk := append([]byte(nil), p[off:end]...)
+		seq := s.nextSeq
+		s.nextSeq++
+
+		s.outQueue = append(s.outQueue, &outSeg{seq: seq, payload: chunk, sentAt: time.Now()})
+		if err := s.sendSegment(seq, chunk, 0); err != nil {
+			return off, err
+		}
+	}
+	return len(p), nil
+}
+
+// Read blocks until at least one byte of in-order data has arrived, then
+// copies as much as fits into p.
+func (s *Stream) Read(p []byte) (int, error) {
+	s.recvMu.Lock()
+	defer s.recvMu.Unlock()
+	for len(s.readBuf) == 0 && !s.closed {
+		s.readCond.Wait()
+	}
+	if len(s.readBuf) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, s.readBuf)
+	s.readBuf = s.readBuf[n:]
+	return n, nil
+}
+
+// Close unblocks any Read in progress with io.EOF.
+func (s *Stream) Close() error {
+	s.recvMu.Lock()
+	s.closed = true
+	s.readCond.Broadcast()
+	s.recvMu.Unlock()
+	return nil
+}
+
+// HandleSegment processes one inbound ReliableSegment plus the payload
+// bytes that followed its header in the carrying VideoFragment -- the
+// app's receive loop calls this for every VideoFragment on
+// protocol.StreamIDReliable instead of handing it to the video path.
+func (s *Stream) HandleSegment(seg protocol.ReliableSegment, payload []byte) {
+	s.sendMu.Lock()
+	kept := s.outQueue[:0]
+	for _, o := range s.outQueue {
+		if o.seq >= seg.Ack {
+			kept = append(kept, o)
+		}
+	}
+	s.outQueue = kept
+	s.sendMu.Unlock()
+
+	if seg.Flags&protocol.FlagReliableACKOnly != 0 || len(payload) == 0 {
+		return
+	}
+
+	s.recvMu.Lock()
+	switch {
+	case seg.Seq == s.recvNext:
+		s.readBuf = append(s.readBuf, payload...)
+		s.recvNext++
+		for {
+			next, ok := s.recvPending[s.recvNext]
+			if !ok {
+				break
+			}
+			s.readBuf = append(s.readBuf, next...)
+			delete(s.recvPending, s.recvNext)
+			s.recvNext++
+		}
+		s.readCond.Broadcast()
+	case seg.Seq > s.recvNext:
+		if len(s.recvPending) < windowSegments {
+			s.recvPending[seg.Seq] = append([]byte(nil), payload...)
+		}
+	}
+	s.recvMu.Unlock()
+
+	s.sendAck()
+}
+
+// RetransmitTick resends any outQueue entry whose RTO has elapsed since it
+// was last (re)sent. Call this periodically -- the whole point of layering
+// ARQ over best-effort UDP.
+func (s *Stream) RetransmitTick() {
+	s.sendMu.Lock()
+	rto := time.Duration(s.rtoMs * float64(time.Millisecond))
+	now := time.Now()
+	var resend []*outSeg
+	for _, o := range s.outQueue {
+		if now.Sub(o.sentAt) >= rto {
+			o.sentAt = now
+			resend = append(resend, o)
+		}
+	}
+	s.sendMu.Unlock()
+
+	for _, o := range resend {
+		s.sendSegment(o.seq, o.payload, 0)
+	}
+}
+
+// sendAck sends a pure ack/window update with no payload, letting the peer
+// trim its outQueue without waiting for this side to have data of its own
+// to piggyback one on.
+func (s *Stream) sendAck() error {
+	return s.sendSegment(0, nil, protocol.FlagReliableACKOnly)
+}
+
+// sendSegment marshals and sends one ReliableSegment, stamping ack/window
+// from this Stream's current receive state.
+func (s *Stream) sendSegment(seq uint32, payload []byte, flags uint8) error {
+	s.recvMu.Lock()
+	ack := s.recvNext
+	window := windowSegments - len(s.recvPending)
+	s.recvMu.Unlock()
+
+	seg := protocol.ReliableSegment{
+		Seq:        seq,
+		Ack:        ack,
+		Window:     uint16(window),
+		Flags:      flags,
+		PayloadLen: uint16(len(payload)),
+	}
+	hdrBuf := make([]byte, protocol.ReliableSegmentHeaderSize)
+	if err := seg.Marshal(hdrBuf); err != nil {
+		return err
+	}
+
+	out := make([]byte, 0, len(hdrBuf)+len(payload))
+	out = append(out, hdrBuf...)
+	out = append(out, payload...)
+
+	frag := protocol.VideoFragment{
+		SessionID: s.sessionID,
+		StreamID:  protocol.StreamIDReliable,
+		Payload:   out,
+	}
+	buf := make([]byte, protocol.VideoFragmentHeaderSize+len(out))
+	n, err := frag.Marshal(buf)
+	if err != nil {
+		return err
+	}
+	return s.bind.Send(buf[:n], s.endpointSnapshot())
+}
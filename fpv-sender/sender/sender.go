@@ -2,44 +2,90 @@
 package sender
 
 import (
+	"encoding/binary"
 	"fmt"
-	"net"
+	"math"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"fpv-sender/fec"
 	"fpv-sender/h264"
+	"fpv-sender/h265"
 	"fpv-sender/protocol"
+	"fpv-sender/rtp"
+	"fpv-sender/sender/cc"
+	"fpv-sender/transport"
 )
 
-// Pacing configuration
+// WireFormat selects how video fragments are framed on the wire.
+type WireFormat int
+
 const (
-	// Target ~2Mbps = 250KB/s = ~208 packets/s at 1200 bytes
-	// So ~4.8ms between packets, but we send in bursts per frame
-	// At 60fps with ~5 packets/frame, pace within the 16ms frame time
-	PacketPaceInterval = 200 * time.Microsecond // 200µs between packets
+	// WireFormatFragment uses the custom MsgTypeVideoFragment framing (default).
+	WireFormatFragment WireFormat = iota
+	// WireFormatRTP uses RFC 6184 RTP payloadization so the stream can be
+	// consumed by standard WebRTC/gstreamer/ffmpeg receivers.
+	WireFormatRTP
 )
 
+// FECConfig holds optional Reed-Solomon forward error correction settings.
+// Disabled by default; when Enabled, every K consecutive data fragments of
+// an Access Unit form a shard group protected by M parity fragments, sent
+// in the same batch after all of the Access Unit's data fragments, with
+// protocol.FlagFEC set. A
+// receiver that's missing up to M fragments of any such group can
+// reconstruct them without an IDR round-trip.
+type FECConfig struct {
+	Enabled bool
+	K       int // data shards per group, default 10
+	M       int // parity shards per group, default 3
+
+	// AdaptiveM lets Packetizer.ReportLoss retune M within [MinM, MaxM] from
+	// an EWMA of a reported loss fraction, instead of M staying fixed for
+	// the packetizer's lifetime.
+	AdaptiveM bool
+	MinM      int // floor for adaptive M, default 1
+	MaxM      int // ceiling for adaptive M, default M*3
+}
+
 // Config holds sender configuration.
 type Config struct {
 	MaxPayloadSize int // Max UDP payload (default 1200)
 	StreamID       uint32
+	WireFormat     WireFormat // Fragment framing vs RTP (default WireFormatFragment)
+	RTPPayloadType uint8      // Dynamic RTP payload type for H.264, default 96
+	Codec          uint8      // protocol.CodecH264 or protocol.CodecH265, default CodecH264
+	FEC            FECConfig  // Reed-Solomon FEC, default disabled
 }
 
 // DefaultConfig returns the default sender configuration.
 func DefaultConfig() Config {
 	return Config{
 		MaxPayloadSize: protocol.MaxPayloadSize,
-		StreamID:       1,
+		StreamID:       protocol.StreamIDVideo,
 	}
 }
 
 // Packetizer fragments Access Units into UDP packets.
 type Packetizer struct {
-	config    Config
-	sessionID uint32
-	frameID   uint32
-	startTime time.Time
-	buf       []byte // Reusable packet buffer
+	config     Config
+	sessionID  uint32
+	frameID    uint32
+	startTime  time.Time
+	buf        []byte // Reusable packet buffer
+	rtpPayload *rtp.Payloader
+	pacer      *cc.Controller // nil disables pacing -- send batches as fast as sendBatch allows, the pre-chunk2-5 behavior
+
+	// fecMu guards everything below: fecCodec and fecTailCodecs are replaced
+	// wholesale by ReportLoss when AdaptiveM retunes M, possibly from a
+	// different goroutine than the one calling Packetize.
+	fecMu         sync.Mutex
+	fecCodec      *fec.Codec
+	fecTailCodecs map[int]*fec.Codec // keyed by actual k, for an AU's short trailing group
+	lossEWMA      float64
+	fecGroupID    uint32 // monotonically increasing across the packetizer's lifetime
+	fecBytesSent  uint64
 }
 
 // NewPacketizer creates a new packetizer.
@@ -47,23 +93,69 @@ func NewPacketizer(sessionID uint32, config Config) *Packetizer {
 	if config.MaxPayloadSize == 0 {
 		config.MaxPayloadSize = protocol.MaxPayloadSize
 	}
-	return &Packetizer{
+	if config.RTPPayloadType == 0 {
+		config.RTPPayloadType = 96
+	}
+	if config.Codec == 0 {
+		config.Codec = protocol.CodecH264
+	}
+	p := &Packetizer{
 		config:    config,
 		sessionID: sessionID,
 		startTime: time.Now(),
 		buf:       make([]byte, config.MaxPayloadSize),
 	}
+	if config.WireFormat == WireFormatRTP {
+		p.rtpPayload = rtp.NewPayloader(sessionID, config.RTPPayloadType, config.MaxPayloadSize)
+	}
+	if config.FEC.Enabled {
+		if config.FEC.K <= 0 {
+			config.FEC.K = 10
+		}
+		if config.FEC.M <= 0 {
+			config.FEC.M = 3
+		}
+		if config.FEC.AdaptiveM {
+			if config.FEC.MinM <= 0 {
+				config.FEC.MinM = 1
+			}
+			if config.FEC.MaxM <= 0 {
+				config.FEC.MaxM = config.FEC.M * 3
+			}
+		}
+		p.config.FEC = config.FEC
+		// An invalid (K, M) disables FEC rather than failing construction;
+		// Packetize falls back to plain fragmentation when fecCodec is nil.
+		p.fecCodec, _ = fec.New(config.FEC.K, config.FEC.M)
+	}
+	return p
 }
 
-// MaxFragmentPayload returns the max payload bytes per fragment.
+// MaxFragmentPayload returns the max payload bytes per fragment. When FEC is
+// enabled this leaves room for the protocol.FECShardHeader so a parity
+// shard -- built from data shards no larger than this -- still fits under
+// MaxPayloadSize once its own header is added.
 func (p *Packetizer) MaxFragmentPayload() int {
-	return p.config.MaxPayloadSize - protocol.VideoFragmentHeaderSize
+	n := p.config.MaxPayloadSize - protocol.VideoFragmentHeaderSize
+	if p.fecCodec != nil {
+		n -= protocol.FECShardHeaderSize + protocol.FECShardLenPrefixSize
+	}
+	return n
 }
 
-// Packetize fragments an Access Unit and sends each fragment via the provided function.
-// The sendFn receives the complete packet ready to send.
-// Returns the number of fragments sent.
-func (p *Packetizer) Packetize(au *h264.AccessUnit, sendFn func([]byte) error) (int, error) {
+// Packetize fragments an Access Unit and hands every fragment -- plus any
+// FEC parity shards -- to sendBatch in a single call, so a caller backed by
+// transport.Bind.SendBatch can coalesce them into one sendmmsg(2)/GSO
+// transmit instead of one syscall per fragment. Returns the number of
+// fragments actually sent, as reported by sendBatch -- 0 if building the
+// batch failed before sendBatch was ever called, otherwise whatever
+// sendBatch reports even on error, since a batched send can make partial
+// progress.
+func (p *Packetizer) Packetize(au *h264.AccessUnit, sendBatch func([][]byte) (int, error)) (int, error) {
+	if p.config.WireFormat == WireFormatRTP {
+		return p.packetizeRTP(au, sendBatch)
+	}
+
 	data := au.Data
 	maxPayload := p.MaxFragmentPayload()
 
@@ -91,8 +183,8 @@ func (p *Packetizer) Packetize(au *h264.AccessUnit, sendFn func([]byte) error) (
 		flags |= protocol.FlagSPSPPS
 	}
 
-	// Send each fragment with pacing to avoid burst loss
-	sent := 0
+	batch := make([][]byte, 0, fragCount)
+	payloads := make([][]byte, 0, fragCount)
 	for i := 0; i < fragCount; i++ {
 		start := i * maxPayload
 		end := start + maxPayload
@@ -100,6 +192,7 @@ func (p *Packetizer) Packetize(au *h264.AccessUnit, sendFn func([]byte) error) (
 			end = len(data)
 		}
 		payload := data[start:end]
+		payloads = append(payloads, payload)
 
 		frag := protocol.VideoFragment{
 			SessionID:  p.sessionID,
@@ -109,29 +202,296 @@ func (p *Packetizer) Packetize(au *h264.AccessUnit, sendFn func([]byte) error) (
 			FragCount:  uint16(fragCount),
 			TsMs:       tsMs,
 			Flags:      flags,
-			Codec:      protocol.CodecH264,
+			Codec:      p.config.Codec,
 			PayloadLen: uint16(len(payload)),
 			Payload:    payload,
 		}
 
 		n, err := frag.Marshal(p.buf)
 		if err != nil {
-			return sent, err
+			return 0, err
 		}
+		pkt := make([]byte, n)
+		copy(pkt, p.buf[:n])
+		batch = append(batch, pkt)
+	}
+
+	fecPkts, fecBytes, err := p.fecShardPackets(frameID, tsMs, p.config.Codec, payloads)
+	if err != nil {
+		return 0, err
+	}
+	batch = append(batch, fecPkts...)
+
+	p.pace(batch)
+	sent, err := sendBatch(batch)
+	if err == nil {
+		atomic.AddUint64(&p.fecBytesSent, fecBytes)
+	}
+	return sent, err
+}
+
+// PacketizeHEVC fragments an HEVC Access Unit, mirroring Packetize but
+// setting VideoFragment.Codec to protocol.CodecH265.
+func (p *Packetizer) PacketizeHEVC(au *h265.AccessUnit, sendBatch func([][]byte) (int, error)) (int, error) {
+	data := au.Data
+	maxPayload := p.MaxFragmentPayload()
+
+	fragCount := (len(data) + maxPayload - 1) / maxPayload
+	if fragCount == 0 {
+		fragCount = 1
+	}
+	if fragCount > 65535 {
+		return 0, fmt.Errorf("AU too large: %d bytes would need %d fragments", len(data), fragCount)
+	}
+
+	frameID := atomic.AddUint32(&p.frameID, 1) - 1
+	tsMs := uint32(time.Since(p.startTime).Milliseconds())
 
-		if err := sendFn(p.buf[:n]); err != nil {
-			// Per spec: if send fails, drop remainder and continue to next AU
-			return sent, err
+	var flags uint8
+	if au.IsKeyframe {
+		flags |= protocol.FlagKeyframe
+	}
+	if au.HasSPSPPS {
+		flags |= protocol.FlagSPSPPS
+	}
+
+	batch := make([][]byte, 0, fragCount)
+	payloads := make([][]byte, 0, fragCount)
+	for i := 0; i < fragCount; i++ {
+		start := i * maxPayload
+		end := start + maxPayload
+		if end > len(data) {
+			end = len(data)
 		}
-		sent++
+		payload := data[start:end]
+		payloads = append(payloads, payload)
 
-		// Pace packets to avoid overwhelming the network
-		if i < fragCount-1 {
-			time.Sleep(PacketPaceInterval)
+		frag := protocol.VideoFragment{
+			SessionID:  p.sessionID,
+			StreamID:   p.config.StreamID,
+			FrameID:    frameID,
+			FragIndex:  uint16(i),
+			FragCount:  uint16(fragCount),
+			TsMs:       tsMs,
+			Flags:      flags,
+			Codec:      protocol.CodecH265,
+			PayloadLen: uint16(len(payload)),
+			Payload:    payload,
 		}
+
+		n, err := frag.Marshal(p.buf)
+		if err != nil {
+			return 0, err
+		}
+		pkt := make([]byte, n)
+		copy(pkt, p.buf[:n])
+		batch = append(batch, pkt)
+	}
+
+	fecPkts, fecBytes, err := p.fecShardPackets(frameID, tsMs, protocol.CodecH265, payloads)
+	if err != nil {
+		return 0, err
 	}
+	batch = append(batch, fecPkts...)
 
-	return sent, nil
+	p.pace(batch)
+	sent, err := sendBatch(batch)
+	if err == nil {
+		atomic.AddUint64(&p.fecBytesSent, fecBytes)
+	}
+	return sent, err
+}
+
+// fecShardPackets computes the Reed-Solomon parity shards for one AU's data
+// fragments, chunked into groups of up to FEC.K shards, each zero-padded to
+// the group's longest fragment, and returns them ready to append to the
+// batch Packetize hands to sendBatch. A trailing group shorter than FEC.K
+// uses its own (actualK, m) codec rather than padding with shards that were
+// never sent -- those could never count toward the k present shards a
+// receiver needs, which would make that group permanently unrecoverable.
+// It's a no-op (nil, 0, nil) when FEC isn't enabled. The second return is
+// the total parity payload bytes, for the caller to add to fecBytesSent
+// once sendBatch has actually sent them.
+func (p *Packetizer) fecShardPackets(frameID uint32, tsMs uint32, codec uint8, payloads [][]byte) ([][]byte, uint64, error) {
+	p.fecMu.Lock()
+	defer p.fecMu.Unlock()
+
+	if p.fecCodec == nil {
+		return nil, 0, nil
+	}
+
+	k, m := p.config.FEC.K, p.config.FEC.M
+	var pkts [][]byte
+	var bytesTotal uint64
+	for start := 0; start < len(payloads); start += k {
+		end := start + k
+		if end > len(payloads) {
+			end = len(payloads)
+		}
+		group := payloads[start:end]
+		actualK := len(group)
+
+		groupCodec := p.fecCodec
+		if actualK != k {
+			var err error
+			groupCodec, err = p.fecCodecForTail(actualK, m)
+			if err != nil {
+				return pkts, bytesTotal, fmt.Errorf("FEC tail codec: %w", err)
+			}
+		}
+
+		shardLen := protocol.FECShardLenPrefixSize
+		for _, s := range group {
+			if len(s)+protocol.FECShardLenPrefixSize > shardLen {
+				shardLen = len(s) + protocol.FECShardLenPrefixSize
+			}
+		}
+
+		shards := make([][]byte, actualK+m)
+		for i := range shards {
+			shards[i] = make([]byte, shardLen)
+		}
+		for i, s := range group {
+			binary.BigEndian.PutUint16(shards[i][:protocol.FECShardLenPrefixSize], uint16(len(s)))
+			copy(shards[i][protocol.FECShardLenPrefixSize:], s)
+		}
+
+		if err := groupCodec.Encode(shards); err != nil {
+			return pkts, bytesTotal, fmt.Errorf("FEC encode: %w", err)
+		}
+
+		groupID := atomic.AddUint32(&p.fecGroupID, 1) - 1
+		hdr := protocol.FECShardHeader{ShardGroup: groupID, K: uint8(actualK), M: uint8(m)}
+		hdrBuf := make([]byte, protocol.FECShardHeaderSize)
+		if err := hdr.Marshal(hdrBuf); err != nil {
+			return pkts, bytesTotal, err
+		}
+
+		for i := 0; i < m; i++ {
+			payload := make([]byte, 0, len(hdrBuf)+len(shards[actualK+i]))
+			payload = append(payload, hdrBuf...)
+			payload = append(payload, shards[actualK+i]...)
+
+			frag := protocol.VideoFragment{
+				SessionID: p.sessionID,
+				StreamID:  p.config.StreamID,
+				FrameID:   frameID,
+				FragIndex: uint16(i),
+				FragCount: uint16(m),
+				TsMs:      tsMs,
+				Flags:     protocol.FlagFEC,
+				Codec:     codec,
+				Payload:   payload,
+			}
+
+			n, err := frag.Marshal(p.buf)
+			if err != nil {
+				return pkts, bytesTotal, err
+			}
+			pkt := make([]byte, n)
+			copy(pkt, p.buf[:n])
+			pkts = append(pkts, pkt)
+			bytesTotal += uint64(len(payload))
+		}
+	}
+
+	return pkts, bytesTotal, nil
+}
+
+// fecCodecForTail returns the cached codec for a short trailing shard group
+// of actualK data shards, building one on first use. Caller must hold
+// p.fecMu (fecShardPackets does for its whole call, since ReportLoss can
+// invalidate this cache mid-AU otherwise).
+func (p *Packetizer) fecCodecForTail(actualK, m int) (*fec.Codec, error) {
+	if c, ok := p.fecTailCodecs[actualK]; ok {
+		return c, nil
+	}
+	c, err := fec.New(actualK, m)
+	if err != nil {
+		return nil, err
+	}
+	if p.fecTailCodecs == nil {
+		p.fecTailCodecs = make(map[int]*fec.Codec)
+	}
+	p.fecTailCodecs[actualK] = c
+	return c, nil
+}
+
+// ReportLoss feeds a fresh fragment-loss fraction (0..1) into an EWMA and,
+// when FEC.AdaptiveM is set, retunes M for shard groups sent after this
+// call so parity overhead tracks current link conditions between FEC.MinM
+// and FEC.MaxM. It's a no-op when AdaptiveM isn't set.
+//
+// The app's inbound Keepalive handler calls this with the same fraction it
+// derives from protocol.Keepalive.PacketsLost for cc.Controller.OnReport --
+// one receiver report, two independent responses to it (FEC parity and
+// pacing rate).
+func (p *Packetizer) ReportLoss(fraction float64) {
+	if !p.config.FEC.AdaptiveM {
+		return
+	}
+
+	p.fecMu.Lock()
+	defer p.fecMu.Unlock()
+
+	p.lossEWMA += (fraction - p.lossEWMA) / 8
+	m := int(math.Ceil(p.lossEWMA * float64(p.config.FEC.K)))
+	if m < p.config.FEC.MinM {
+		m = p.config.FEC.MinM
+	}
+	if m > p.config.FEC.MaxM {
+		m = p.config.FEC.MaxM
+	}
+	if m == p.config.FEC.M {
+		return
+	}
+
+	codec, err := fec.New(p.config.FEC.K, m)
+	if err != nil {
+		return
+	}
+	p.config.FEC.M = m
+	p.fecCodec = codec
+	p.fecTailCodecs = nil // cached tail codecs were built against the old M
+}
+
+// SetPacer attaches pc as this Packetizer's congestion-controlled pacer.
+// pc may be nil to disable pacing, which is also the default -- batches go
+// out as fast as sendBatch allows, same as before this existed.
+func (p *Packetizer) SetPacer(pc *cc.Controller) {
+	p.pacer = pc
+}
+
+// pace holds batch to the pacer's current rate via its token bucket,
+// sleeping once for the whole Access Unit's bytes rather than the old
+// fixed sleep per packet -- a no-op if no pacer is attached.
+func (p *Packetizer) pace(batch [][]byte) {
+	if p.pacer == nil {
+		return
+	}
+	n := 0
+	for _, b := range batch {
+		n += len(b)
+	}
+	if d := p.pacer.Reserve(n); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// packetizeRTP payloads an Access Unit as RFC 6184 RTP packets instead of
+// the custom VideoFragment framing, for interop with WebRTC/gstreamer/ffmpeg
+// receivers negotiated via Hello.Flags (HelloFlagWireFormatRTP).
+func (p *Packetizer) packetizeRTP(au *h264.AccessUnit, sendBatch func([][]byte) (int, error)) (int, error) {
+	atomic.AddUint32(&p.frameID, 1)
+	tsMs := uint32(time.Since(p.startTime).Milliseconds())
+
+	packets, err := p.rtpPayload.Payload(au, tsMs)
+	if err != nil {
+		return 0, err
+	}
+
+	p.pace(packets)
+	return sendBatch(packets)
 }
 
 // FrameID returns the current frame ID.
@@ -146,36 +506,54 @@ type Stats struct {
 	BytesSent     uint64
 	SendErrors    uint64
 	KeyframesSent uint64
+	FECBytesSent  uint64 // bytes spent on Reed-Solomon parity shards, for overhead visibility
 }
 
 // Sender manages the UDP connection and sends video.
 type Sender struct {
-	conn       *net.UDPConn
-	peerAddr   *net.UDPAddr
+	bind       transport.Bind
+	endpoint   transport.Endpoint
 	packetizer *Packetizer
 	stats      Stats
 }
 
-// NewSender creates a new sender.
-func NewSender(conn *net.UDPConn, peerAddr *net.UDPAddr, sessionID uint32) *Sender {
+// NewSender creates a new sender with the default configuration.
+func NewSender(bind transport.Bind, endpoint transport.Endpoint, sessionID uint32) *Sender {
+	return NewSenderWithConfig(bind, endpoint, sessionID, DefaultConfig())
+}
+
+// NewSenderWithConfig creates a new sender with a custom configuration, e.g.
+// to select WireFormatRTP.
+func NewSenderWithConfig(bind transport.Bind, endpoint transport.Endpoint, sessionID uint32, config Config) *Sender {
 	return &Sender{
-		conn:       conn,
-		peerAddr:   peerAddr,
-		packetizer: NewPacketizer(sessionID, DefaultConfig()),
+		bind:       bind,
+		endpoint:   endpoint,
+		packetizer: NewPacketizer(sessionID, config),
 	}
 }
 
+// WireFormat returns the configured wire format.
+func (s *Sender) WireFormat() WireFormat {
+	return s.packetizer.config.WireFormat
+}
+
 // SendAccessUnit sends a complete Access Unit.
 func (s *Sender) SendAccessUnit(au *h264.AccessUnit) error {
-	n, err := s.packetizer.Packetize(au, func(packet []byte) error {
-		_, err := s.conn.WriteToUDP(packet, s.peerAddr)
-		if err != nil {
-			atomic.AddUint64(&s.stats.SendErrors, 1)
-			return err
+	n, err := s.packetizer.Packetize(au, s.sendBatch)
+
+	atomic.AddUint64(&s.stats.FragmentsSent, uint64(n))
+	if err == nil {
+		atomic.AddUint64(&s.stats.FramesSent, 1)
+		if au.IsKeyframe {
+			atomic.AddUint64(&s.stats.KeyframesSent, 1)
 		}
-		atomic.AddUint64(&s.stats.BytesSent, uint64(len(packet)))
-		return nil
-	})
+	}
+	return err
+}
+
+// SendAccessUnitHEVC sends a complete HEVC Access Unit.
+func (s *Sender) SendAccessUnitHEVC(au *h265.AccessUnit) error {
+	n, err := s.packetizer.PacketizeHEVC(au, s.sendBatch)
 
 	atomic.AddUint64(&s.stats.FragmentsSent, uint64(n))
 	if err == nil {
@@ -187,6 +565,25 @@ func (s *Sender) SendAccessUnit(au *h264.AccessUnit) error {
 	return err
 }
 
+// sendBatch hands a packetizer's batch of fragments for one Access Unit off
+// to the transport in a single SendBatch call, updating stats from however
+// many of them actually reached the wire -- a batched send can make partial
+// progress even when it ultimately errors.
+func (s *Sender) sendBatch(batch [][]byte) (int, error) {
+	n, err := s.bind.SendBatch(batch, s.endpoint)
+
+	var bytesSent uint64
+	for _, pkt := range batch[:n] {
+		bytesSent += uint64(len(pkt))
+	}
+	atomic.AddUint64(&s.stats.BytesSent, bytesSent)
+
+	if err != nil {
+		atomic.AddUint64(&s.stats.SendErrors, 1)
+	}
+	return n, err
+}
+
 // SendKeepalive sends a keepalive packet.
 func (s *Sender) SendKeepalive(sessionID uint32, seq uint32, echoTsMs uint32) error {
 	k := protocol.Keepalive{
@@ -202,8 +599,20 @@ func (s *Sender) SendKeepalive(sessionID uint32, seq uint32, echoTsMs uint32) er
 		return err
 	}
 
-	_, err = s.conn.WriteToUDP(buf, s.peerAddr)
-	return err
+	return s.bind.Send(buf, s.endpoint)
+}
+
+// SendHello sends a capabilities announcement.
+func (s *Sender) SendHello(hello protocol.Hello) error {
+	hello.SessionID = s.packetizer.sessionID
+
+	buf := make([]byte, protocol.HelloHeaderSize)
+	_, err := hello.Marshal(buf)
+	if err != nil {
+		return err
+	}
+
+	return s.bind.Send(buf, s.endpoint)
 }
 
 // SendProbe sends a probe packet for hole punching.
@@ -223,8 +632,7 @@ func (s *Sender) SendProbe(sessionID uint32, seq uint32, nonce uint64) error {
 		return err
 	}
 
-	_, err = s.conn.WriteToUDP(buf, s.peerAddr)
-	return err
+	return s.bind.Send(buf, s.endpoint)
 }
 
 // Stats returns current statistics.
@@ -235,10 +643,40 @@ func (s *Sender) Stats() Stats {
 		BytesSent:     atomic.LoadUint64(&s.stats.BytesSent),
 		SendErrors:    atomic.LoadUint64(&s.stats.SendErrors),
 		KeyframesSent: atomic.LoadUint64(&s.stats.KeyframesSent),
+		FECBytesSent:  atomic.LoadUint64(&s.packetizer.fecBytesSent),
 	}
 }
 
-// SetPeerAddr updates the peer address (after hole punching).
-func (s *Sender) SetPeerAddr(addr *net.UDPAddr) {
-	s.peerAddr = addr
+// SetEndpoint updates the peer endpoint (after hole punching).
+func (s *Sender) SetEndpoint(ep transport.Endpoint) {
+	s.endpoint = ep
+}
+
+// SetPacer attaches pc as the congestion controller pacing this Sender's
+// outgoing batches (see Packetizer.SetPacer). pc may be nil to disable
+// pacing.
+func (s *Sender) SetPacer(pc *cc.Controller) {
+	s.packetizer.SetPacer(pc)
+}
+
+// ReportLoss forwards to the packetizer's adaptive-FEC loss EWMA (see
+// Packetizer.ReportLoss).
+func (s *Sender) ReportLoss(fraction float64) {
+	s.packetizer.ReportLoss(fraction)
+}
+
+// CurrentFrameID returns the frame ID the packetizer will assign to the
+// next Access Unit, for comparing against a receiver-reported
+// highestFrameID to gauge how many frames are still in flight.
+func (s *Sender) CurrentFrameID() uint32 {
+	return s.packetizer.FrameID()
+}
+
+// ElapsedMs returns milliseconds since this Sender's packetizer started,
+// the same clock TsMs in its own outgoing Keepalives/Probes is stamped
+// from. A caller computes its own RTT from an inbound Keepalive's
+// EchoTsMs by subtracting it from this -- the mirror-your-own-clock-back
+// pattern sender.MultiPath's readLoop already uses.
+func (s *Sender) ElapsedMs() uint32 {
+	return uint32(time.Since(s.packetizer.startTime).Milliseconds())
 }
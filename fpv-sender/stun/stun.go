@@ -6,9 +6,10 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"errors"
-	"fmt"
 	"net"
 	"time"
+
+	"fpv-sender/transport"
 )
 
 // STUN message types
@@ -39,45 +40,61 @@ var (
 	ErrInvalidReply = errors.New("invalid STUN reply")
 )
 
-// Result contains the discovered endpoints.
+// Result contains the discovered server-reflexive endpoints. Either may be
+// nil if no server returned a candidate of that family (most commonly
+// PublicAddr6, on a host or network without an IPv6 stack).
 type Result struct {
-	LocalAddr  *net.UDPAddr // Local bound address
-	PublicAddr *net.UDPAddr // Server-reflexive (public) address
-	Server     string       // STUN server that responded
+	PublicAddr4 *net.UDPAddr
+	PublicAddr6 *net.UDPAddr
+	Server      string // last server that answered either family
 }
 
-// Discover performs STUN binding to discover the public endpoint.
-// It uses the provided UDP connection (which should already be bound).
-func Discover(ctx context.Context, conn *net.UDPConn, servers []string) (*Result, error) {
+// Discover performs STUN binding over bind to discover the public
+// endpoint(s), trying each server in turn until it has gathered one IPv4
+// and one IPv6 candidate (or run out of servers).
+func Discover(ctx context.Context, bind transport.Bind, servers []string) (*Result, error) {
 	if servers == nil {
 		servers = DefaultServers
 	}
 
-	localAddr := conn.LocalAddr().(*net.UDPAddr)
-
+	result := &Result{}
 	for _, server := range servers {
-		serverAddr, err := net.ResolveUDPAddr("udp4", server)
-		if err != nil {
-			continue
+		if result.PublicAddr4 == nil {
+			if addr, err := doBinding(ctx, bind, server, false); err == nil {
+				result.PublicAddr4 = addr
+				result.Server = server
+			}
 		}
-
-		publicAddr, err := doBinding(ctx, conn, serverAddr)
-		if err != nil {
-			continue
+		if result.PublicAddr6 == nil {
+			if addr, err := doBinding(ctx, bind, server, true); err == nil {
+				result.PublicAddr6 = addr
+				result.Server = server
+			}
+		}
+		if result.PublicAddr4 != nil && result.PublicAddr6 != nil {
+			break
 		}
-
-		return &Result{
-			LocalAddr:  localAddr,
-			PublicAddr: publicAddr,
-			Server:     server,
-		}, nil
 	}
 
-	return nil, ErrNoResponse
+	if result.PublicAddr4 == nil && result.PublicAddr6 == nil {
+		return nil, ErrNoResponse
+	}
+	return result, nil
 }
 
-// doBinding sends a STUN binding request and waits for response.
-func doBinding(ctx context.Context, conn *net.UDPConn, server *net.UDPAddr) (*net.UDPAddr, error) {
+// doBinding sends a STUN binding request to server over the given address
+// family and waits for a response, making up to 3 attempts.
+func doBinding(ctx context.Context, bind transport.Bind, server string, v6 bool) (*net.UDPAddr, error) {
+	network := "udp4"
+	if v6 {
+		network = "udp6"
+	}
+	serverAddr, err := net.ResolveUDPAddr(network, server)
+	if err != nil {
+		return nil, err
+	}
+	ep := transport.Endpoint{IP: serverAddr.IP, Port: serverAddr.Port}
+
 	// Generate transaction ID (12 bytes)
 	txnID := make([]byte, 12)
 	if _, err := rand.Read(txnID); err != nil {
@@ -91,22 +108,30 @@ func doBinding(ctx context.Context, conn *net.UDPConn, server *net.UDPAddr) (*ne
 	binary.BigEndian.PutUint32(req[4:8], magicCookie)
 	copy(req[8:20], txnID)
 
-	// Set read deadline
 	deadline, ok := ctx.Deadline()
 	if !ok {
 		deadline = time.Now().Add(2 * time.Second)
 	}
-	conn.SetReadDeadline(deadline)
+
+	recv := bind.ReceiveIPv4
+	if v6 {
+		recv = bind.ReceiveIPv6
+	}
 
 	// Send request (with retries)
 	for attempt := 0; attempt < 3; attempt++ {
-		if _, err := conn.WriteToUDP(req, server); err != nil {
+		if err := bind.Send(req, ep); err != nil {
 			return nil, err
 		}
 
-		// Wait for response
+		readDeadline := deadline
+		if perAttempt := time.Now().Add(700 * time.Millisecond); perAttempt.Before(readDeadline) {
+			readDeadline = perAttempt
+		}
+		bind.SetReadDeadline(readDeadline)
+
 		buf := make([]byte, 1024)
-		n, _, err := conn.ReadFromUDP(buf)
+		n, _, err := recv(buf)
 		if err != nil {
 			if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
 				continue // Retry on timeout
@@ -114,7 +139,6 @@ func doBinding(ctx context.Context, conn *net.UDPConn, server *net.UDPAddr) (*ne
 			return nil, err
 		}
 
-		// Parse response
 		addr, err := parseResponse(buf[:n], txnID)
 		if err != nil {
 			continue
@@ -182,6 +206,20 @@ func parseResponse(buf []byte, expectedTxnID []byte) (*net.UDPAddr, error) {
 				)
 				return &net.UDPAddr{IP: ip, Port: int(port)}, nil
 			}
+			if family == 0x02 && attrLen >= 20 { // IPv6
+				xport := binary.BigEndian.Uint16(attrData[2:4])
+				port := xport ^ uint16(magicCookie>>16)
+
+				cookie := make([]byte, 16)
+				binary.BigEndian.PutUint32(cookie[0:4], magicCookie)
+				copy(cookie[4:16], expectedTxnID)
+
+				xaddr := make([]byte, 16)
+				for i := 0; i < 16; i++ {
+					xaddr[i] = attrData[4+i] ^ cookie[i]
+				}
+				return &net.UDPAddr{IP: net.IP(xaddr), Port: int(port)}, nil
+			}
 		} else if attrType == attrMappedAddress && attrLen >= 8 {
 			// MAPPED-ADDRESS (fallback)
 			family := attrData[1]
@@ -190,6 +228,12 @@ func parseResponse(buf []byte, expectedTxnID []byte) (*net.UDPAddr, error) {
 				ip := net.IPv4(attrData[4], attrData[5], attrData[6], attrData[7])
 				return &net.UDPAddr{IP: ip, Port: int(port)}, nil
 			}
+			if family == 0x02 && attrLen >= 20 { // IPv6
+				port := binary.BigEndian.Uint16(attrData[2:4])
+				ip := make(net.IP, 16)
+				copy(ip, attrData[4:20])
+				return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+			}
 		}
 
 		// Move to next attribute (padded to 4-byte boundary)
@@ -199,19 +243,19 @@ func parseResponse(buf []byte, expectedTxnID []byte) (*net.UDPAddr, error) {
 	return nil, ErrInvalidReply
 }
 
-// DiscoverWithNewSocket creates a new UDP socket, performs STUN, and returns both.
-func DiscoverWithNewSocket(ctx context.Context, localPort int) (*net.UDPConn, *Result, error) {
-	addr := &net.UDPAddr{IP: net.IPv4zero, Port: localPort}
-	conn, err := net.ListenUDP("udp4", addr)
+// DiscoverWithNewBind opens a new dual-stack transport.Bind on localPort,
+// performs STUN discovery over it, and returns both.
+func DiscoverWithNewBind(ctx context.Context, localPort int) (*transport.UDPBind, *Result, error) {
+	bind, _, err := transport.NewUDPBind(localPort)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create UDP socket: %w", err)
+		return nil, nil, err
 	}
 
-	result, err := Discover(ctx, conn, nil)
+	result, err := Discover(ctx, bind, nil)
 	if err != nil {
-		conn.Close()
+		bind.Close()
 		return nil, nil, err
 	}
 
-	return conn, result, nil
+	return bind, result, nil
 }
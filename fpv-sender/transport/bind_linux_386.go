@@ -0,0 +1,7 @@
+//go:build linux && 386
+
+package transport
+
+// SYS_SENDMMSG isn't in package syscall for 386 (unlike most other Linux
+// ports, where it is) -- this is its syscall number from the 386 table.
+const sysSendmmsg = 345
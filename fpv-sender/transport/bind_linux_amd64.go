@@ -0,0 +1,7 @@
+//go:build linux && amd64
+
+package transport
+
+// SYS_SENDMMSG isn't in package syscall for amd64 (unlike most other Linux
+// ports, where it is) -- this is its syscall number from the amd64 table.
+const sysSendmmsg = 307
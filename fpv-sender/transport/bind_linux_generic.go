@@ -0,0 +1,8 @@
+//go:build linux && !amd64 && !386
+
+package transport
+
+import "syscall"
+
+// Every other Linux port package syscall already defines SYS_SENDMMSG for.
+const sysSendmmsg = syscall.SYS_SENDMMSG
@@ -0,0 +1,32 @@
+//go:build linux
+
+package transport
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// bindToDevice pins a not-yet-bound socket to a specific network interface
+// via SO_BINDTODEVICE, so its traffic only ever goes out (and is only
+// accepted from) that interface -- e.g. wwan0 even though the kernel's
+// routing table would otherwise prefer wlan0. This is how a
+// sender.MultiPath's Paths stay on their own physical link instead of
+// racing the same default route.
+//
+// It must run from a net.ListenConfig.Control callback (i.e. before
+// bind(2)), not after: SO_BINDTODEVICE is what lets several sockets share
+// one wildcard address:port across different interfaces in the first
+// place, and that only works if the kernel knows the device at bind time.
+func bindToDevice(raw syscall.RawConn, device string) error {
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, device)
+	}); err != nil {
+		return err
+	}
+	if sockErr != nil {
+		return fmt.Errorf("transport: SO_BINDTODEVICE %s: %w", device, sockErr)
+	}
+	return nil
+}
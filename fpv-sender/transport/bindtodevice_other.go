@@ -0,0 +1,16 @@
+//go:build !linux
+
+package transport
+
+import (
+	"errors"
+	"syscall"
+)
+
+// errBindToDeviceUnsupported is returned by NewUDPBindOnDevice on platforms
+// with no SO_BINDTODEVICE equivalent wired up.
+var errBindToDeviceUnsupported = errors.New("transport: SO_BINDTODEVICE is Linux-only")
+
+func bindToDevice(raw syscall.RawConn, device string) error {
+	return errBindToDeviceUnsupported
+}
@@ -0,0 +1,113 @@
+package transport
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// enableRecvPktinfo4 turns on IP_PKTINFO ancillary data for conn's IPv4
+// socket, so every ReadMsgUDP reports the local address a packet arrived
+// on.
+func enableRecvPktinfo4(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_PKTINFO, 1)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// enableRecvPktinfo6 is enableRecvPktinfo4 for the IPv6 socket. Note the
+// option that enables receiving it is IPV6_RECVPKTINFO, distinct from the
+// IPV6_PKTINFO type the resulting control message carries.
+func enableRecvPktinfo6(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_RECVPKTINFO, 1)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// parsePktinfoDst scans oob for an IP_PKTINFO (or, if v6, IPV6_PKTINFO)
+// control message and returns the destination address it reports -- the
+// local address the packet arrived on, which is what a reply to its
+// sender should be sourced from.
+func parsePktinfoDst(oob []byte, v6 bool) (net.IP, bool) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, false
+	}
+	for _, m := range msgs {
+		if v6 {
+			if m.Header.Level != syscall.IPPROTO_IPV6 || m.Header.Type != syscall.IPV6_PKTINFO {
+				continue
+			}
+			if len(m.Data) < syscall.SizeofInet6Pktinfo {
+				continue
+			}
+			info := (*syscall.Inet6Pktinfo)(unsafe.Pointer(&m.Data[0]))
+			ip := make(net.IP, 16)
+			copy(ip, info.Addr[:])
+			return ip, true
+		}
+		if m.Header.Level != syscall.IPPROTO_IP || m.Header.Type != syscall.IP_PKTINFO {
+			continue
+		}
+		if len(m.Data) < syscall.SizeofInet4Pktinfo {
+			continue
+		}
+		info := (*syscall.Inet4Pktinfo)(unsafe.Pointer(&m.Data[0]))
+		ip := make(net.IP, 4)
+		copy(ip, info.Addr[:])
+		return ip, true
+	}
+	return nil, false
+}
+
+// buildPktinfo constructs an IP_PKTINFO (or IPV6_PKTINFO) control message
+// requesting src as the outgoing packet's source address. Returns nil for a
+// src that doesn't match the requested family.
+func buildPktinfo(src net.IP, v6 bool) []byte {
+	if v6 {
+		ip := src.To16()
+		if ip == nil || src.To4() != nil {
+			return nil
+		}
+		data := make([]byte, syscall.SizeofInet6Pktinfo)
+		copy(data[0:16], ip) // in6_pktinfo.ipi6_addr
+		return marshalCmsg(syscall.IPPROTO_IPV6, syscall.IPV6_PKTINFO, data)
+	}
+	ip := src.To4()
+	if ip == nil {
+		return nil
+	}
+	data := make([]byte, syscall.SizeofInet4Pktinfo)
+	copy(data[4:8], ip) // in_pktinfo.ipi_spec_dst: source address to send from
+	return marshalCmsg(syscall.IPPROTO_IP, syscall.IP_PKTINFO, data)
+}
+
+// marshalCmsg builds a single control message of the given level/type
+// wrapping data, via syscall.Cmsghdr directly so the header's field widths
+// and alignment match the host architecture instead of a hand-picked
+// layout.
+func marshalCmsg(level, typ int, data []byte) []byte {
+	buf := make([]byte, syscall.CmsgSpace(len(data)))
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&buf[0]))
+	h.Level = int32(level)
+	h.Type = int32(typ)
+	h.SetLen(syscall.CmsgLen(len(data)))
+	copy(buf[syscall.CmsgLen(0):], data)
+	return buf
+}
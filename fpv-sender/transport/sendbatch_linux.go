@@ -0,0 +1,166 @@
+//go:build linux
+
+package transport
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// mmsghdr mirrors Linux's struct mmsghdr (bits/socket.h). Package syscall
+// wraps the single-message sendmsg(2) as Sendmsg but has no sendmmsg(2)
+// binding or message-vector type, so this is hand-rolled the same way
+// pktinfo.go hand-rolls IP_PKTINFO/IPV6_PKTINFO -- Go's struct layout rules
+// match the kernel's C ABI here, so no explicit padding is needed.
+type mmsghdr struct {
+	Hdr syscall.Msghdr
+	Len uint32
+}
+
+// udpSegment is UDP_SEGMENT from linux/udp.h (kernel 4.18+), the GSO cmsg
+// type that tells the kernel to fan one sendmsg out into segSize-sized wire
+// packets. Not in package syscall's socket-option list.
+const udpSegment = 103
+
+// platformSendBatch sends bufs to dst in as few syscalls as this host's
+// kernel allows: one UDP_SEGMENT (GSO) datagram when bufs are equal length
+// apart from a shorter trailing one -- every fragment of an Access Unit but
+// the last is the same full size, so this is the common case -- or a single
+// sendmmsg(2) call otherwise (e.g. a batch carrying FEC parity shards, which
+// are longer than the data fragments they protect). A kernel too old for UDP_SEGMENT just fails the cmsg and
+// falls back to sendmmsg rather than erroring the whole batch. Returns how
+// many of bufs actually reached the wire -- all of them for GSO (it's one
+// datagram, sent or not), possibly fewer than len(bufs) for sendmmsg if the
+// kernel accepted a partial vector before erroring.
+func platformSendBatch(conn *net.UDPConn, bufs [][]byte, dst *net.UDPAddr, src net.IP, v6 bool) (int, error) {
+	if segLen, ok := uniformSegmentLen(bufs); ok {
+		if err := sendGSO(conn, bufs, segLen, dst, src, v6); err == nil {
+			return len(bufs), nil
+		}
+	}
+	return sendMmsg(conn, bufs, dst, src, v6)
+}
+
+// uniformSegmentLen reports whether every buf but the last is the same
+// length -- GSO requires a uniform segment size, with only the final
+// segment allowed to be shorter -- and returns that length.
+func uniformSegmentLen(bufs [][]byte) (int, bool) {
+	if len(bufs) < 2 {
+		return 0, false
+	}
+	segLen := len(bufs[0])
+	for _, b := range bufs[:len(bufs)-1] {
+		if len(b) != segLen {
+			return 0, false
+		}
+	}
+	if len(bufs[len(bufs)-1]) > segLen {
+		return 0, false
+	}
+	return segLen, true
+}
+
+// sendGSO coalesces bufs into one UDP_SEGMENT datagram so the kernel/NIC
+// does the fan-out instead of this goroutine looping per fragment.
+func sendGSO(conn *net.UDPConn, bufs [][]byte, segLen int, dst *net.UDPAddr, src net.IP, v6 bool) error {
+	total := 0
+	for _, b := range bufs {
+		total += len(b)
+	}
+	payload := make([]byte, 0, total)
+	for _, b := range bufs {
+		payload = append(payload, b...)
+	}
+
+	segSize := make([]byte, 2)
+	*(*uint16)(unsafe.Pointer(&segSize[0])) = uint16(segLen) // gso_size is host byte order, not network order
+	oob := marshalCmsg(syscall.IPPROTO_UDP, udpSegment, segSize)
+	if src != nil {
+		oob = append(oob, buildPktinfo(src, v6)...)
+	}
+
+	_, _, err := conn.WriteMsgUDP(payload, oob, dst)
+	return err
+}
+
+// sendMmsg sends bufs to dst via sendmmsg(2), retrying with whatever's left
+// if the kernel accepts fewer messages than requested in one call. Returns
+// how many of bufs it actually handed to the kernel before any error --
+// sendmmsg can make partial progress, so a non-nil error doesn't mean the
+// whole batch was lost.
+func sendMmsg(conn *net.UDPConn, bufs [][]byte, dst *net.UDPAddr, src net.IP, v6 bool) (int, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	name, namelen := rawSockaddr(dst, v6)
+
+	var oob []byte
+	if src != nil {
+		oob = buildPktinfo(src, v6)
+	}
+
+	iovs := make([]syscall.Iovec, len(bufs))
+	hdrs := make([]mmsghdr, len(bufs))
+	for i, b := range bufs {
+		iovs[i].SetLen(len(b))
+		if len(b) > 0 {
+			iovs[i].Base = &b[0]
+		}
+		hdrs[i].Hdr.Name = name
+		hdrs[i].Hdr.Namelen = namelen
+		hdrs[i].Hdr.Iov = &iovs[i]
+		hdrs[i].Hdr.Iovlen = 1
+		if len(oob) > 0 {
+			hdrs[i].Hdr.Control = &oob[0]
+			hdrs[i].Hdr.SetControllen(len(oob))
+		}
+	}
+
+	sent := 0
+	var sendErr error
+	ctrlErr := raw.Control(func(fd uintptr) {
+		for sent < len(hdrs) {
+			n, _, errno := syscall.Syscall6(sysSendmmsg, fd,
+				uintptr(unsafe.Pointer(&hdrs[sent])), uintptr(len(hdrs)-sent), 0, 0, 0)
+			if errno != 0 {
+				sendErr = errno
+				return
+			}
+			if n == 0 {
+				sendErr = syscall.EIO
+				return
+			}
+			sent += int(n)
+		}
+	})
+	if ctrlErr != nil {
+		return sent, ctrlErr
+	}
+	return sent, sendErr
+}
+
+// rawSockaddr builds the sockaddr_in/sockaddr_in6 bytes Msghdr.Name points
+// at for dst, mirroring how pktinfo.go reaches straight for syscall's raw
+// structs instead of going through the Sockaddr interface (which sendmmsg
+// has no use for -- every message here shares one destination).
+func rawSockaddr(dst *net.UDPAddr, v6 bool) (*byte, uint32) {
+	if v6 {
+		var sa syscall.RawSockaddrInet6
+		sa.Family = syscall.AF_INET6
+		sa.Port = htons(uint16(dst.Port))
+		copy(sa.Addr[:], dst.IP.To16())
+		return (*byte)(unsafe.Pointer(&sa)), uint32(unsafe.Sizeof(sa))
+	}
+	var sa syscall.RawSockaddrInet4
+	sa.Family = syscall.AF_INET
+	sa.Port = htons(uint16(dst.Port))
+	copy(sa.Addr[:], dst.IP.To4())
+	return (*byte)(unsafe.Pointer(&sa)), uint32(unsafe.Sizeof(sa))
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
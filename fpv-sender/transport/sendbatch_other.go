@@ -0,0 +1,21 @@
+//go:build !linux
+
+package transport
+
+import "net"
+
+// platformSendBatch has no batched send syscall to reach for outside Linux,
+// so it just loops the same per-packet WriteMsgUDP Send uses, returning how
+// many of bufs went out before any error.
+func platformSendBatch(conn *net.UDPConn, bufs [][]byte, dst *net.UDPAddr, src net.IP, v6 bool) (int, error) {
+	var oob []byte
+	if src != nil {
+		oob = buildPktinfo(src, v6)
+	}
+	for i, b := range bufs {
+		if _, _, err := conn.WriteMsgUDP(b, oob, dst); err != nil {
+			return i, err
+		}
+	}
+	return len(bufs), nil
+}
@@ -0,0 +1,76 @@
+// Package transport abstracts UDP send/receive behind a Bind that remembers,
+// per destination, which local address a reply to it should leave from. On
+// a multi-homed host (e.g. a Pi with both Wi-Fi and LTE) the kernel's
+// default route would otherwise send a reply out whichever interface it
+// prefers rather than the one the peer's packet actually arrived on, which
+// breaks UDP hole punching. The design mirrors the source-caching a
+// WireGuard-style conn package does via IP_PKTINFO/IPV6_PKTINFO, and lets
+// one socket gather both an IPv4 and an IPv6 server-reflexive candidate.
+package transport
+
+import (
+	"net"
+	"strconv"
+	"time"
+)
+
+// Endpoint identifies a UDP peer plus, once known, the local address a
+// packet to it should be sourced from. SrcIP is the zero value until a
+// Bind has learned it from a received packet's IP_PKTINFO/IPV6_PKTINFO
+// ancillary data -- a zero SrcIP lets the kernel pick the source as usual,
+// and Send falls back to whatever source a Bind has already cached for
+// this destination.
+type Endpoint struct {
+	IP    net.IP
+	Port  int
+	SrcIP net.IP
+}
+
+// Addr returns the endpoint's destination as a *net.UDPAddr.
+func (e Endpoint) Addr() *net.UDPAddr {
+	return &net.UDPAddr{IP: e.IP, Port: e.Port}
+}
+
+func (e Endpoint) String() string {
+	return e.Addr().String()
+}
+
+// dstKey identifies an endpoint by destination only, for the src-address
+// cache -- two Endpoint values for the same peer with different SrcIPs
+// (e.g. one freshly received, one reconstructed from a signaling exchange)
+// must hit the same cache entry.
+func (e Endpoint) dstKey() string {
+	return net.JoinHostPort(e.IP.String(), strconv.Itoa(e.Port))
+}
+
+// Bind is a dual-stack UDP socket abstraction that tracks, for every peer
+// it has received a packet from, the local address that packet arrived on,
+// and sources subsequent packets to that peer from the same local address.
+type Bind interface {
+	// ReceiveIPv4 reads the next IPv4 packet into buf, returning the
+	// number of bytes read and the sender's Endpoint (SrcIP populated
+	// from IP_PKTINFO when the kernel reports it).
+	ReceiveIPv4(buf []byte) (n int, ep Endpoint, err error)
+	// ReceiveIPv6 is ReceiveIPv4 for the IPv6 socket.
+	ReceiveIPv6(buf []byte) (n int, ep Endpoint, err error)
+	// Send writes buf to ep, sourcing it from ep.SrcIP -- or, if that's
+	// unset, the most recently learned source for ep's destination -- via
+	// IP_PKTINFO/IPV6_PKTINFO.
+	Send(buf []byte, ep Endpoint) error
+	// SendBatch writes bufs to ep in as few syscalls as the platform allows
+	// -- a single UDP_SEGMENT (GSO) datagram when bufs are equal length, or
+	// one sendmmsg(2) call otherwise on Linux -- sourcing them the same way
+	// Send does. Platforms without a batched send path fall back to looping
+	// Send. Intended for a caller like Packetizer that has a whole Access
+	// Unit's fragments ready at once and would otherwise pace them with a
+	// per-fragment sleep. Returns how many of bufs actually reached the wire
+	// before any error, since a batched send can make partial progress.
+	SendBatch(bufs [][]byte, ep Endpoint) (int, error)
+	// SetReadDeadline applies a read deadline to both address families.
+	SetReadDeadline(t time.Time) error
+	// HasIPv6 reports whether the IPv6 socket is usable, so a caller knows
+	// whether it's worth running a ReceiveIPv6 loop at all.
+	HasIPv6() bool
+	// Close closes the underlying sockets.
+	Close() error
+}
@@ -0,0 +1,239 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrIPv6Unavailable is returned by ReceiveIPv6 and by Send for an IPv6
+// destination when a UDPBind's IPv6 socket failed to open (e.g. no IPv6
+// stack on this host). IPv4 keeps working either way.
+var ErrIPv6Unavailable = errors.New("transport: ipv6 socket unavailable")
+
+// UDPBind is the concrete Bind: one udp4 and one udp6 *net.UDPConn sharing
+// a single port, with IP_PKTINFO/IPV6_PKTINFO enabled on each so Receive
+// reports, and Send can set, the packet's local address.
+type UDPBind struct {
+	ipv4 *net.UDPConn
+	ipv6 *net.UDPConn // nil if the IPv6 listener failed to open
+
+	mu       sync.Mutex
+	srcByDst map[string]net.IP
+}
+
+// NewUDPBind opens a dual-stack UDP listener on port (0 for an OS-assigned
+// port), returning the bound port. A failure to open the IPv6 side is
+// non-fatal -- IPv6 candidates and peers just won't be reachable -- since
+// plenty of networks (and containers) don't have an IPv6 stack at all.
+func NewUDPBind(port int) (*UDPBind, int, error) {
+	return newUDPBind(port, "")
+}
+
+// NewUDPBindOnDevice is NewUDPBind restricted to a single network interface
+// via SO_BINDTODEVICE (e.g. "wwan0"), for a sender.Path whose traffic must
+// stay on that interface instead of whichever one the kernel's default
+// route would otherwise pick.
+func NewUDPBindOnDevice(port int, device string) (*UDPBind, int, error) {
+	return newUDPBind(port, device)
+}
+
+func newUDPBind(port int, device string) (*UDPBind, int, error) {
+	// Bonding multiple devices means several sockets bind the same
+	// address:port -- same port number if --port is fixed (so
+	// firewall/NAT rules only need to admit one), same 0 if it's left to
+	// the kernel. SO_BINDTODEVICE has to be set before bind(2) for the
+	// kernel to treat those sockets as distinct (one per interface)
+	// instead of conflicting, and SO_REUSEADDR is what makes the bind
+	// itself legal once they are -- listenUDP sets both from within its
+	// ListenConfig.Control callback, ahead of the bind.
+	ipv4Conn, err := listenUDP("udp4", port, device)
+	if err != nil {
+		return nil, 0, fmt.Errorf("transport: listen udp4: %w", err)
+	}
+	actualPort := ipv4Conn.LocalAddr().(*net.UDPAddr).Port
+
+	if err := enableRecvPktinfo4(ipv4Conn); err != nil {
+		ipv4Conn.Close()
+		return nil, 0, fmt.Errorf("transport: enable IP_PKTINFO: %w", err)
+	}
+
+	b := &UDPBind{
+		ipv4:     ipv4Conn,
+		srcByDst: make(map[string]net.IP),
+	}
+
+	ipv6Conn, err := listenUDP("udp6", actualPort, device)
+	if err != nil {
+		return b, actualPort, nil
+	}
+	if err := enableRecvPktinfo6(ipv6Conn); err != nil {
+		ipv6Conn.Close()
+		return b, actualPort, nil
+	}
+	b.ipv6 = ipv6Conn
+
+	return b, actualPort, nil
+}
+
+// listenUDP opens a UDP listener on port. When device is non-empty, it binds
+// the socket to that interface via SO_BINDTODEVICE and sets SO_REUSEADDR,
+// both from the pre-bind ListenConfig.Control callback -- SO_BINDTODEVICE
+// must land before bind(2) for several sockets (one per sender.Path) to
+// share a single port number across distinct interfaces at all, and
+// SO_REUSEADDR is what then makes that shared bind legal.
+func listenUDP(network string, port int, device string) (*net.UDPConn, error) {
+	if device == "" {
+		return net.ListenUDP(network, &net.UDPAddr{Port: port})
+	}
+
+	lc := net.ListenConfig{
+		Control: func(_, _ string, c syscall.RawConn) error {
+			if err := bindToDevice(c, device); err != nil {
+				return err
+			}
+			var sockErr error
+			if err := c.Control(func(fd uintptr) {
+				sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+			}); err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+	pc, err := lc.ListenPacket(context.Background(), network, fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, err
+	}
+	return pc.(*net.UDPConn), nil
+}
+
+// SetSendBufferSize sets the OS send buffer on both sockets.
+func (b *UDPBind) SetSendBufferSize(bytes int) error {
+	if err := b.ipv4.SetWriteBuffer(bytes); err != nil {
+		return err
+	}
+	if b.ipv6 != nil {
+		return b.ipv6.SetWriteBuffer(bytes)
+	}
+	return nil
+}
+
+// LocalPort returns the shared port both sockets are bound to.
+func (b *UDPBind) LocalPort() int {
+	return b.ipv4.LocalAddr().(*net.UDPAddr).Port
+}
+
+// HasIPv6 reports whether the IPv6 socket opened successfully.
+func (b *UDPBind) HasIPv6() bool {
+	return b.ipv6 != nil
+}
+
+func (b *UDPBind) ReceiveIPv4(buf []byte) (int, Endpoint, error) {
+	return b.receive(b.ipv4, buf, false)
+}
+
+func (b *UDPBind) ReceiveIPv6(buf []byte) (int, Endpoint, error) {
+	if b.ipv6 == nil {
+		return 0, Endpoint{}, ErrIPv6Unavailable
+	}
+	return b.receive(b.ipv6, buf, true)
+}
+
+func (b *UDPBind) receive(conn *net.UDPConn, buf []byte, v6 bool) (int, Endpoint, error) {
+	oob := make([]byte, 128)
+	n, oobn, _, addr, err := conn.ReadMsgUDP(buf, oob)
+	if err != nil {
+		return 0, Endpoint{}, err
+	}
+
+	ep := Endpoint{IP: addr.IP, Port: addr.Port}
+	if src, ok := parsePktinfoDst(oob[:oobn], v6); ok {
+		ep.SrcIP = src
+		b.mu.Lock()
+		b.srcByDst[ep.dstKey()] = src
+		b.mu.Unlock()
+	}
+	return n, ep, nil
+}
+
+func (b *UDPBind) Send(buf []byte, ep Endpoint) error {
+	src := ep.SrcIP
+	if src == nil {
+		b.mu.Lock()
+		src = b.srcByDst[ep.dstKey()]
+		b.mu.Unlock()
+	}
+
+	v6 := ep.IP.To4() == nil
+	conn := b.ipv4
+	if v6 {
+		if b.ipv6 == nil {
+			return ErrIPv6Unavailable
+		}
+		conn = b.ipv6
+	}
+
+	var oob []byte
+	if src != nil {
+		oob = buildPktinfo(src, v6)
+	}
+	_, _, err := conn.WriteMsgUDP(buf, oob, ep.Addr())
+	return err
+}
+
+// SendBatch writes bufs to ep in as few syscalls as this platform supports,
+// resolving the source address once and reusing it for every buf exactly as
+// Send does. Packetizer uses this to hand off a whole Access Unit's
+// fragments together instead of calling Send once per fragment with a
+// pacing sleep between them. Returns how many of bufs actually reached the
+// wire before any error -- a batched send can make partial progress.
+func (b *UDPBind) SendBatch(bufs [][]byte, ep Endpoint) (int, error) {
+	if len(bufs) == 0 {
+		return 0, nil
+	}
+
+	src := ep.SrcIP
+	if src == nil {
+		b.mu.Lock()
+		src = b.srcByDst[ep.dstKey()]
+		b.mu.Unlock()
+	}
+
+	v6 := ep.IP.To4() == nil
+	conn := b.ipv4
+	if v6 {
+		if b.ipv6 == nil {
+			return 0, ErrIPv6Unavailable
+		}
+		conn = b.ipv6
+	}
+
+	return platformSendBatch(conn, bufs, ep.Addr(), src, v6)
+}
+
+func (b *UDPBind) SetReadDeadline(t time.Time) error {
+	if err := b.ipv4.SetReadDeadline(t); err != nil {
+		return err
+	}
+	if b.ipv6 != nil {
+		return b.ipv6.SetReadDeadline(t)
+	}
+	return nil
+}
+
+func (b *UDPBind) Close() error {
+	err4 := b.ipv4.Close()
+	var err6 error
+	if b.ipv6 != nil {
+		err6 = b.ipv6.Close()
+	}
+	if err4 != nil {
+		return err4
+	}
+	return err6
+}
@@ -0,0 +1,79 @@
+// Package authtoken implements the expiry+HMAC token scheme used to
+// authenticate control-plane requests (telemetry reconnects, browser
+// clients) without handing out the long-lived control secret itself.
+//
+// A token is `<expiry-hex><signature-hex>`: an 8-char hex Unix expiry
+// followed by an HMAC-SHA256 of that expiry string, keyed by the secret.
+package authtoken
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// legacySignatureLen is generate-token.js's truncated signature length.
+const legacySignatureLen = 16
+
+// Manager mints and verifies tokens signed with a single secret.
+type Manager struct {
+	secret []byte
+	legacy bool // truncate signatures to legacySignatureLen, for generate-token.js compatibility
+}
+
+// NewManager creates a Manager for secret. When legacy is true, minted
+// signatures are truncated to match the existing generate-token.js format;
+// Verify accepts both full and truncated signatures regardless, so legacy
+// only controls what newly-minted tokens look like.
+func NewManager(secret string, legacy bool) *Manager {
+	return &Manager{secret: []byte(secret), legacy: legacy}
+}
+
+// Generate mints a token valid for ttl from now.
+func (m *Manager) Generate(ttl time.Duration) string {
+	if len(m.secret) == 0 {
+		return ""
+	}
+	return m.sign(time.Now().Add(ttl).Unix())
+}
+
+func (m *Manager) sign(expiry int64) string {
+	expiryHex := fmt.Sprintf("%08x", expiry)
+	h := hmac.New(sha256.New, m.secret)
+	h.Write([]byte(expiryHex))
+	sig := hex.EncodeToString(h.Sum(nil))
+	if m.legacy {
+		sig = sig[:legacySignatureLen]
+	}
+	return expiryHex + sig
+}
+
+// Verify reports whether token is well-formed, unexpired, and correctly
+// signed for this Manager's secret. It accepts both full-length and
+// generate-token.js's truncated signature so either format keeps working
+// regardless of which one Generate currently mints. Comparison is
+// constant-time.
+func (m *Manager) Verify(token string) bool {
+	if len(m.secret) == 0 || len(token) <= 8 {
+		return false
+	}
+	expiryHex, sig := token[:8], token[8:]
+	expiry, err := strconv.ParseInt(expiryHex, 16, 64)
+	if err != nil {
+		return false
+	}
+	if time.Now().Unix() > expiry {
+		return false
+	}
+
+	h := hmac.New(sha256.New, m.secret)
+	h.Write([]byte(expiryHex))
+	full := hex.EncodeToString(h.Sum(nil))
+	if len(sig) != legacySignatureLen && len(sig) != len(full) {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(full[:len(sig)]))
+}
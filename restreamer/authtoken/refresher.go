@@ -0,0 +1,38 @@
+package authtoken
+
+import (
+	"sync"
+	"time"
+)
+
+// Refresher caches a Manager-minted token and only mints a new one once the
+// cached one is within refreshBefore of expiring, so a reconnect loop that
+// calls Token() on every attempt reuses one token across many attempts
+// instead of minting (and discarding) a fresh one each time.
+type Refresher struct {
+	mgr           *Manager
+	ttl           time.Duration
+	refreshBefore time.Duration
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// NewRefresher creates a Refresher that mints ttl-lived tokens from mgr,
+// refreshing refreshBefore ahead of expiry.
+func NewRefresher(mgr *Manager, ttl, refreshBefore time.Duration) *Refresher {
+	return &Refresher{mgr: mgr, ttl: ttl, refreshBefore: refreshBefore}
+}
+
+// Token returns a currently-valid token, minting a new one first if the
+// cached token is unset or close to expiring.
+func (r *Refresher) Token() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.token == "" || time.Now().After(r.expiry.Add(-r.refreshBefore)) {
+		r.token = r.mgr.Generate(r.ttl)
+		r.expiry = time.Now().Add(r.ttl)
+	}
+	return r.token
+}
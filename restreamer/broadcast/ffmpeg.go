@@ -0,0 +1,176 @@
+package broadcast
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// muxerForURL picks the FFmpeg output muxer for a destination URL's scheme,
+// for use in a `-f tee` slot spec (e.g. "[f=flv]rtmp://...").
+func muxerForURL(rawURL string) (string, error) {
+	switch {
+	case strings.HasPrefix(rawURL, "rtmp://"), strings.HasPrefix(rawURL, "rtmps://"):
+		return "flv", nil
+	case strings.HasPrefix(rawURL, "srt://"):
+		return "mpegts", nil
+	default:
+		return "", fmt.Errorf("unsupported destination URL scheme: %s", rawURL)
+	}
+}
+
+// buildTeeSpec builds the single `-f tee` output argument that forks one
+// encode to every destination, e.g. "[f=flv]url1|[f=flv]url2|[f=mpegts]url3".
+func buildTeeSpec(dests []Destination) (string, error) {
+	slots := make([]string, 0, len(dests))
+	for _, d := range dests {
+		muxer, err := muxerForURL(d.URL)
+		if err != nil {
+			return "", err
+		}
+		slots = append(slots, fmt.Sprintf("[f=%s]%s", muxer, d.URL))
+	}
+	return strings.Join(slots, "|"), nil
+}
+
+// recordingSlot builds the `-f tee` slot that saves the feed as local
+// 10-minute MP4 segments (telemetry overlay already burned in) alongside
+// the live push, named by wall-clock time. The janitor orders them by file
+// modification time, not by name.
+func recordingSlot(dir string) string {
+	return fmt.Sprintf("[f=segment:segment_time=600:reset_timestamps=1:strftime=1]%s/%%Y%%m%%d-%%H%%M%%S.mp4", dir)
+}
+
+// startFFmpeg builds and starts the FFmpeg command for the current config,
+// then hands it off to the supervisor loop. Safe to call both for the
+// initial start and for a crash-triggered restart.
+func (m *Manager) startFFmpeg(stopCh chan struct{}) error {
+	m.mu.Lock()
+	cfg := m.cfg
+	m.mu.Unlock()
+
+	teeSpec, err := buildTeeSpec(cfg.Destinations)
+	if err != nil {
+		return err
+	}
+	if cfg.RecordDir != "" {
+		if err := os.MkdirAll(cfg.RecordDir, 0755); err != nil {
+			return fmt.Errorf("broadcast: creating record dir: %w", err)
+		}
+		teeSpec += "|" + recordingSlot(cfg.RecordDir)
+	}
+
+	// Build FFmpeg command with optional telemetry overlay
+	args := []string{
+		"-hide_banner", "-loglevel", "info",
+		"-rtsp_transport", "tcp",
+		"-i", fmt.Sprintf("rtsp://127.0.0.1:8554/%s", cfg.CarPath),
+		"-f", "lavfi", "-i", "anullsrc=channel_layout=stereo:sample_rate=44100", // silent audio
+	}
+
+	if cfg.TelemetryOverlay {
+		// Use drawtext filter with reload=1 to read telemetry.txt
+		args = append(args,
+			"-vf", "drawtext=fontfile=/usr/share/fonts/dejavu/DejaVuSans-Bold.ttf:"+
+				"textfile=/tmp/telemetry.txt:reload=1:"+
+				"x=20:y=h-50:fontsize=24:fontcolor=white:"+
+				"box=1:boxcolor=black@0.6:boxborderw=8",
+		)
+	}
+
+	// Encode once, fork to every destination via the tee muxer - cheaper
+	// than running one FFmpeg per destination and keeps them all in sync.
+	args = append(args,
+		"-c:v", "libx264", "-preset", "veryfast", "-tune", "zerolatency",
+		"-b:v", "2500k", "-maxrate", "3000k", "-bufsize", "6000k",
+		"-pix_fmt", "yuv420p",
+		"-g", "60", "-keyint_min", "60",
+		"-c:a", "aac", "-b:a", "128k",
+		"-shortest",
+		"-f", "tee", teeSpec,
+	)
+
+	cmd := exec.Command("ffmpeg", args...)
+	cmd.Stdout = os.Stdout
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.ffmpegCmd = cmd
+	m.mu.Unlock()
+
+	m.resetDestinationStatus(cfg.Destinations)
+	go m.watchFFmpegStderr(stderr)
+	go m.superviseFFmpeg(cmd, stopCh)
+
+	log.Printf("broadcast: ffmpeg started (PID: %d), destinations: %v", cmd.Process.Pid, cfg.Destinations)
+	return nil
+}
+
+// killFFmpeg kills the FFmpeg process, if running. Its exit is observed
+// and reaped by the supervisor goroutine's cmd.Wait(), not here.
+func (m *Manager) killFFmpeg() {
+	m.mu.Lock()
+	cmd := m.ffmpegCmd
+	m.ffmpegCmd = nil
+	m.mu.Unlock()
+
+	if cmd != nil && cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+}
+
+// resetDestinationStatus (re)initializes per-destination status, called
+// whenever FFmpeg (re)starts with a given destination list.
+func (m *Manager) resetDestinationStatus(dests []Destination) {
+	m.destMu.Lock()
+	defer m.destMu.Unlock()
+	m.destStatus = make(map[string]*DestinationStatus, len(dests))
+	for _, d := range dests {
+		m.destStatus[d.Name] = &DestinationStatus{Name: d.Name, URL: d.URL, Connected: true, UpdatedAt: time.Now()}
+	}
+}
+
+// watchFFmpegStderr logs FFmpeg's stderr line by line and flags a
+// destination as disconnected when its URL shows up in an error line -
+// FFmpeg's tee muxer reports per-slot write failures this way rather than
+// with a separate stats line per output.
+func (m *Manager) watchFFmpegStderr(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		log.Println("[ffmpeg]", line)
+		m.markDestinationErrorFromLine(line)
+	}
+}
+
+func (m *Manager) markDestinationErrorFromLine(line string) {
+	lower := strings.ToLower(line)
+	isError := strings.Contains(lower, "error") || strings.Contains(lower, "fail") ||
+		strings.Contains(lower, "refused") || strings.Contains(lower, "broken pipe") ||
+		strings.Contains(lower, "timed out")
+	if !isError {
+		return
+	}
+
+	m.destMu.Lock()
+	defer m.destMu.Unlock()
+	for _, status := range m.destStatus {
+		if strings.Contains(line, status.URL) {
+			status.Connected = false
+			status.LastError = line
+			status.UpdatedAt = time.Now()
+		}
+	}
+}
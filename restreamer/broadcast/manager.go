@@ -0,0 +1,415 @@
+// Package broadcast owns the MediaMTX + FFmpeg restreaming pipeline as a
+// small state machine (inspired by neko's BroadcastManagerCtx), so HTTP
+// handlers just translate requests into Start/Stop/Restart calls instead of
+// juggling *exec.Cmd and a shared mutex directly.
+package broadcast
+
+import (
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// State is where the pipeline is in its lifecycle.
+type State int
+
+const (
+	StateIdle State = iota
+	StateStarting
+	StateStreaming
+	StateDegraded
+	StateStopping
+)
+
+func (s State) String() string {
+	switch s {
+	case StateIdle:
+		return "idle"
+	case StateStarting:
+		return "starting"
+	case StateStreaming:
+		return "streaming"
+	case StateDegraded:
+		return "degraded"
+	case StateStopping:
+		return "stopping"
+	default:
+		return "unknown"
+	}
+}
+
+// initialBackoff and maxBackoff bound the exponential backoff applied
+// between FFmpeg restart attempts after an unexpected crash.
+const (
+	initialBackoff = 1 * time.Second
+	maxBackoff     = 30 * time.Second
+)
+
+// Destination is one target the encoded feed is tee'd to.
+type Destination struct {
+	Name string `json:"name"`
+	URL  string `json:"url"` // rtmp(s):// or srt://
+}
+
+// DestinationStatus is the per-destination health derived from FFmpeg's
+// stderr.
+type DestinationStatus struct {
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	Connected bool      `json:"connected"`
+	LastError string    `json:"last_error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Redacted returns a copy with URL and LastError cleared. Both can contain
+// the destination's embedded RTMP/SRT stream key (LastError is FFmpeg
+// stderr, which echoes the URL it failed to connect to) -- callers serving
+// status to unauthenticated requesters must use this instead of the raw
+// DestinationStatus.
+func (s DestinationStatus) Redacted() DestinationStatus {
+	s.URL = ""
+	s.LastError = ""
+	return s
+}
+
+// RedactDestinations returns a copy of statuses with every entry redacted,
+// for handlers (like /status and /events) that serve destination health to
+// unauthenticated callers.
+func RedactDestinations(statuses []DestinationStatus) []DestinationStatus {
+	redacted := make([]DestinationStatus, len(statuses))
+	for i, s := range statuses {
+		redacted[i] = s.Redacted()
+	}
+	return redacted
+}
+
+// Config configures a single Start call.
+type Config struct {
+	CarPath          string // MediaMTX path serving the camera feed, e.g. "car"
+	CamWhepURL       string // optional: MediaMTX pulls this instead of accepting a push
+	TelemetryOverlay bool   // burn in the telemetry.txt drawtext overlay
+	Destinations     []Destination
+
+	RecordDir      string // optional: also save segmented local MP4s here via the tee muxer
+	RecordMaxBytes int64  // janitor deletes oldest recordings once RecordDir exceeds this; 0 disables it
+}
+
+// Event is broadcast to subscribers whenever the manager's state or
+// per-destination health changes.
+type Event struct {
+	State        State
+	Message      string
+	Destinations []DestinationStatus
+	Time         time.Time
+}
+
+// Manager owns the MediaMTX and FFmpeg processes and supervises FFmpeg,
+// restarting it with exponential backoff if it crashes unexpectedly.
+type Manager struct {
+	mu     sync.Mutex
+	cfg    Config
+	state  State
+	stopCh chan struct{} // closed by Stop() so the supervisor knows an exit was requested
+
+	mediamtxCmd *exec.Cmd
+	ffmpegCmd   *exec.Cmd
+
+	destMu     sync.Mutex
+	destStatus map[string]*DestinationStatus
+
+	subMu sync.Mutex
+	subs  map[chan Event]struct{}
+
+	janitorStop chan struct{}
+	closeOnce   sync.Once
+}
+
+// NewManager creates an idle Manager and starts its recording janitor.
+func NewManager() *Manager {
+	m := &Manager{
+		state:       StateIdle,
+		destStatus:  map[string]*DestinationStatus{},
+		subs:        map[chan Event]struct{}{},
+		janitorStop: make(chan struct{}),
+	}
+	go m.runJanitor(m.janitorStop)
+	return m
+}
+
+// State returns the manager's current state.
+func (m *Manager) State() State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+// Subscribe registers a channel that receives every future Event. Call the
+// returned cancel func to unsubscribe and release the channel.
+func (m *Manager) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, 8)
+	m.subMu.Lock()
+	m.subs[ch] = struct{}{}
+	m.subMu.Unlock()
+
+	cancel := func() {
+		m.subMu.Lock()
+		if _, ok := m.subs[ch]; ok {
+			delete(m.subs, ch)
+			close(ch)
+		}
+		m.subMu.Unlock()
+	}
+	return ch, cancel
+}
+
+// emit transitions to state, and broadcasts the transition (with the
+// current destination statuses) to every subscriber. Slow subscribers drop
+// events rather than block the manager.
+func (m *Manager) emit(state State, message string) {
+	m.mu.Lock()
+	m.state = state
+	m.mu.Unlock()
+
+	ev := Event{
+		State:        state,
+		Message:      message,
+		Destinations: m.DestinationStatuses(),
+		Time:         time.Now(),
+	}
+
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+	for ch := range m.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// EnsureMediaMTX starts MediaMTX if it isn't already running, without
+// touching FFmpeg. Safe to call before Start, e.g. at process boot so
+// WHIP pushes have somewhere to land immediately.
+func (m *Manager) EnsureMediaMTX(cfg Config) error {
+	m.mu.Lock()
+	m.cfg = cfg
+	m.mu.Unlock()
+	return m.startMediaMTX()
+}
+
+// Start moves the pipeline from Idle/Degraded to Streaming: it ensures
+// MediaMTX is running, waits for the camera path to be ready, then starts
+// FFmpeg and hands it off to the supervisor loop.
+func (m *Manager) Start(cfg Config) error {
+	m.mu.Lock()
+	if m.state != StateIdle && m.state != StateDegraded {
+		state := m.state
+		m.mu.Unlock()
+		return fmt.Errorf("broadcast: already %s", state)
+	}
+	if len(cfg.Destinations) == 0 {
+		m.mu.Unlock()
+		return fmt.Errorf("broadcast: no destinations configured")
+	}
+	m.cfg = cfg
+	m.state = StateStarting
+	stopCh := make(chan struct{})
+	m.stopCh = stopCh
+	m.mu.Unlock()
+	m.emit(StateStarting, "starting broadcast")
+
+	if err := m.startMediaMTX(); err != nil {
+		m.emit(StateIdle, err.Error())
+		return err
+	}
+	if err := m.waitForStream(cfg.CarPath, 30*time.Second); err != nil {
+		m.emit(StateIdle, err.Error())
+		return err
+	}
+	if err := m.startFFmpeg(stopCh); err != nil {
+		m.emit(StateIdle, err.Error())
+		return err
+	}
+
+	m.emit(StateStreaming, "streaming")
+	return nil
+}
+
+// Stop tears down FFmpeg (without triggering the crash supervisor) and
+// MediaMTX, and returns the manager to Idle.
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	if m.state == StateIdle {
+		m.mu.Unlock()
+		return
+	}
+	m.state = StateStopping
+	stopCh := m.stopCh
+	m.mu.Unlock()
+	m.emit(StateStopping, "stopping")
+
+	if stopCh != nil {
+		close(stopCh) // tell the supervisor this exit was requested, not a crash
+	}
+	m.killFFmpeg()
+	m.killMediaMTX()
+
+	m.mu.Lock()
+	m.state = StateIdle
+	m.stopCh = nil
+	m.mu.Unlock()
+	m.emit(StateIdle, "stopped")
+}
+
+// Restart stops and starts the pipeline again with its current config, so
+// destination changes take effect immediately while live.
+func (m *Manager) Restart() error {
+	m.mu.Lock()
+	cfg := m.cfg
+	m.mu.Unlock()
+	m.Stop()
+	return m.Start(cfg)
+}
+
+// IsStreaming reports whether a broadcast is active: Streaming, or Degraded
+// while the supervisor restarts a crashed FFmpeg (which is then not running).
+func (m *Manager) IsStreaming() bool {
+	state := m.State()
+	return state == StateStreaming || state == StateDegraded
+}
+
+// superviseFFmpeg waits for cmd to exit. A Stop()-requested exit (stopCh
+// closed) ends the loop; an unexpected crash is retried with exponential
+// backoff until it succeeds or Stop() is called.
+func (m *Manager) superviseFFmpeg(cmd *exec.Cmd, stopCh chan struct{}) {
+	err := cmd.Wait()
+	select {
+	case <-stopCh:
+		return
+	default:
+	}
+
+	m.emit(StateDegraded, fmt.Sprintf("ffmpeg exited unexpectedly: %v", err))
+
+	backoff := initialBackoff
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(backoff):
+		}
+
+		if err := m.startFFmpeg(stopCh); err != nil {
+			m.emit(StateDegraded, fmt.Sprintf("ffmpeg restart failed: %v", err))
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		m.emit(StateStreaming, "ffmpeg restarted")
+		return
+	}
+}
+
+// RestartError wraps a Restart() failure that happened after the
+// destination list edit it was applying had already succeeded. Callers must
+// not treat it like a validation error (the edit itself was fine) -- Stop()
+// has already run by the time Restart() can fail, so the broadcast is down
+// on every destination, not just rejected.
+type RestartError struct {
+	Err error
+}
+
+func (e *RestartError) Error() string {
+	return fmt.Sprintf("destination list updated but restart failed: %v", e.Err)
+}
+
+func (e *RestartError) Unwrap() error { return e.Err }
+
+// AddDestination appends a new destination, restarting FFmpeg to pick it up
+// if currently streaming.
+func (m *Manager) AddDestination(d Destination) error {
+	if _, err := muxerForURL(d.URL); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	for _, existing := range m.cfg.Destinations {
+		if existing.Name == d.Name {
+			m.mu.Unlock()
+			return fmt.Errorf("destination %q already exists", d.Name)
+		}
+	}
+	m.cfg.Destinations = append(m.cfg.Destinations, d)
+	streaming := m.state == StateStreaming || m.state == StateDegraded
+	m.mu.Unlock()
+
+	if streaming {
+		if err := m.Restart(); err != nil {
+			return &RestartError{Err: err}
+		}
+	}
+	return nil
+}
+
+// RemoveDestination drops a destination by name, restarting FFmpeg to pick
+// it up if currently streaming.
+func (m *Manager) RemoveDestination(name string) error {
+	m.mu.Lock()
+	found := false
+	kept := m.cfg.Destinations[:0:0]
+	for _, d := range m.cfg.Destinations {
+		if d.Name == name {
+			found = true
+			continue
+		}
+		kept = append(kept, d)
+	}
+	m.cfg.Destinations = kept
+	streaming := m.state == StateStreaming || m.state == StateDegraded
+	m.mu.Unlock()
+
+	if !found {
+		return fmt.Errorf("destination %q not found", name)
+	}
+	if streaming {
+		if err := m.Restart(); err != nil {
+			return &RestartError{Err: err}
+		}
+	}
+	return nil
+}
+
+// Destinations returns a snapshot of the configured destination list.
+func (m *Manager) Destinations() []Destination {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Destination(nil), m.cfg.Destinations...)
+}
+
+// DestinationStatuses returns a snapshot of per-destination health, in
+// destination order.
+func (m *Manager) DestinationStatuses() []DestinationStatus {
+	m.mu.Lock()
+	dests := append([]Destination(nil), m.cfg.Destinations...)
+	m.mu.Unlock()
+
+	m.destMu.Lock()
+	defer m.destMu.Unlock()
+	list := make([]DestinationStatus, 0, len(dests))
+	for _, d := range dests {
+		if s, ok := m.destStatus[d.Name]; ok {
+			list = append(list, *s)
+		}
+	}
+	return list
+}
+
+// Close tears everything down, for process shutdown. Safe to call more than
+// once.
+func (m *Manager) Close() {
+	m.Stop()
+	m.closeOnce.Do(func() { close(m.janitorStop) })
+}
@@ -0,0 +1,122 @@
+package broadcast
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	mediaMTXConfigPath = "/tmp/mediamtx.yml"
+	mediaMTXAPIAddr    = "127.0.0.1:9997"
+)
+
+// writeMediaMTXConfig writes the MediaMTX config for the camera path from
+// cfg. When CamWhepURL is set we still pull it; otherwise the path is left
+// sourceless so MediaMTX accepts a push (our WHIP bridge, or a direct
+// WHIP publisher) instead.
+//
+// Takes cfg instead of reading m.cfg itself so callers that already hold
+// m.mu (startMediaMTX) can pass their own snapshot without deadlocking on
+// the non-reentrant mutex.
+func (m *Manager) writeMediaMTXConfig(cfg Config) error {
+	carPath := fmt.Sprintf("  %s:\n    sourceOnDemand: no\n", cfg.CarPath)
+	if cfg.CamWhepURL != "" {
+		// Strip protocol from URL - MediaMTX uses whep:// (HTTP) or wheps:// (HTTPS)
+		whepURL := cfg.CamWhepURL
+		scheme := "whep"
+		if strings.HasPrefix(whepURL, "https://") {
+			scheme = "wheps"
+			whepURL = strings.TrimPrefix(whepURL, "https://")
+		} else {
+			whepURL = strings.TrimPrefix(whepURL, "http://")
+		}
+		carPath = fmt.Sprintf("  %s:\n    source: %s://%s\n    sourceOnDemand: no\n", cfg.CarPath, scheme, whepURL)
+	}
+
+	config := fmt.Sprintf(`logLevel: info
+logDestinations: [stdout]
+
+api: yes
+apiAddress: %s
+
+rtsp: yes
+rtspAddress: :8554
+
+webrtc: yes
+webrtcAddress: :8889
+
+paths:
+%s`, mediaMTXAPIAddr, carPath)
+
+	return os.WriteFile(mediaMTXConfigPath, []byte(config), 0644)
+}
+
+// startMediaMTX starts MediaMTX if it isn't already running.
+func (m *Manager) startMediaMTX() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.mediamtxCmd != nil && m.mediamtxCmd.Process != nil {
+		if err := m.mediamtxCmd.Process.Signal(syscall.Signal(0)); err == nil {
+			return nil
+		}
+	}
+
+	if err := m.writeMediaMTXConfig(m.cfg); err != nil {
+		return err
+	}
+
+	cmd := exec.Command("mediamtx", mediaMTXConfigPath)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	m.mediamtxCmd = cmd
+
+	log.Printf("broadcast: MediaMTX started (PID: %d)", cmd.Process.Pid)
+	return nil
+}
+
+// killMediaMTX kills the MediaMTX process, if running.
+func (m *Manager) killMediaMTX() {
+	m.mu.Lock()
+	cmd := m.mediamtxCmd
+	m.mediamtxCmd = nil
+	m.mu.Unlock()
+
+	if cmd != nil && cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+}
+
+// waitForStream polls MediaMTX's API until path has tracks ready.
+func (m *Manager) waitForStream(path string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		resp, err := http.Get(fmt.Sprintf("http://%s/v3/paths/get/%s", mediaMTXAPIAddr, path))
+		if err == nil {
+			if resp.StatusCode == 200 {
+				var result map[string]interface{}
+				if json.NewDecoder(resp.Body).Decode(&result) == nil {
+					if ready, ok := result["ready"].(bool); ok && ready {
+						log.Printf("broadcast: stream '%s' is ready", path)
+						resp.Body.Close()
+						return nil
+					}
+					log.Printf("broadcast: waiting for stream '%s'... (ready=%v)", path, result["ready"])
+				}
+			}
+			resp.Body.Close()
+		}
+		time.Sleep(2 * time.Second)
+	}
+	return fmt.Errorf("timeout waiting for stream '%s'", path)
+}
@@ -0,0 +1,140 @@
+package broadcast
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recording describes one segment file saved under Config.RecordDir.
+type Recording struct {
+	Name            string    `json:"name"`
+	SizeBytes       int64     `json:"size_bytes"`
+	DurationSeconds float64   `json:"duration_seconds,omitempty"`
+	ModTime         time.Time `json:"mod_time"`
+}
+
+// ListRecordings returns the configured RecordDir's .mp4 segments, oldest
+// first.
+func (m *Manager) ListRecordings() ([]Recording, error) {
+	dir := m.recordDir()
+	if dir == "" {
+		return nil, fmt.Errorf("broadcast: recording not configured")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	recordings := make([]Recording, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".mp4" {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		duration, _ := probeDuration(path)
+		recordings = append(recordings, Recording{
+			Name:            e.Name(),
+			SizeBytes:       info.Size(),
+			DurationSeconds: duration,
+			ModTime:         info.ModTime(),
+		})
+	}
+	sort.Slice(recordings, func(i, j int) bool { return recordings[i].ModTime.Before(recordings[j].ModTime) })
+	return recordings, nil
+}
+
+// RecordingPath resolves name to an absolute path inside RecordDir, rejecting
+// any attempt to escape it (e.g. "../../etc/passwd").
+func (m *Manager) RecordingPath(name string) (string, error) {
+	if name == "" || filepath.Base(name) != name || strings.Contains(name, "..") {
+		return "", fmt.Errorf("broadcast: invalid recording name")
+	}
+	dir := m.recordDir()
+	if dir == "" {
+		return "", fmt.Errorf("broadcast: recording not configured")
+	}
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("broadcast: recording not found")
+	}
+	return path, nil
+}
+
+func (m *Manager) recordDir() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cfg.RecordDir
+}
+
+// probeDuration shells out to ffprobe for a recording's duration; a failure
+// (e.g. ffprobe missing, or the segment is still being written) just means
+// DurationSeconds is omitted, not a hard error.
+func probeDuration(path string) (float64, error) {
+	out, err := exec.Command("ffprobe", "-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1", path).Output()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+}
+
+// janitorInterval is how often the recording janitor checks RecordDir
+// against RecordMaxBytes.
+const janitorInterval = 1 * time.Minute
+
+// runJanitor periodically deletes the oldest recordings once RecordDir
+// exceeds RecordMaxBytes, until stop is closed.
+func (m *Manager) runJanitor(stop <-chan struct{}) {
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			m.enforceRecordingQuota()
+		}
+	}
+}
+
+func (m *Manager) enforceRecordingQuota() {
+	m.mu.Lock()
+	dir, maxBytes := m.cfg.RecordDir, m.cfg.RecordMaxBytes
+	m.mu.Unlock()
+	if dir == "" || maxBytes <= 0 {
+		return
+	}
+
+	recordings, err := m.ListRecordings()
+	if err != nil {
+		return
+	}
+	var total int64
+	for _, r := range recordings {
+		total += r.SizeBytes
+	}
+	for _, r := range recordings {
+		if total <= maxBytes {
+			break
+		}
+		if err := os.Remove(filepath.Join(dir, r.Name)); err != nil {
+			continue
+		}
+		total -= r.SizeBytes
+	}
+}
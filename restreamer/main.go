@@ -2,45 +2,69 @@ package main
 
 import (
 	"bytes"
-	"crypto/hmac"
-	"crypto/sha256"
+	"crypto/rand"
+	"crypto/subtle"
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/pion/webrtc/v4"
+
+	"restreamer/authtoken"
+	"restreamer/broadcast"
 )
 
 var (
-	ffmpegCmd   *exec.Cmd
-	mediamtxCmd *exec.Cmd
-	mu          sync.Mutex
+	mgr = broadcast.NewManager()
 
 	camWhepURL        = getEnv("CAM_WHEP_URL", "https://cam.example.com/cam/whep")
 	telemetryOfferURL = getEnv("TELEMETRY_OFFER_URL", "") // e.g., https://control.example.com/telemetry/offer
 	tokenSecret       = getEnv("TOKEN_SECRET", "")        // Shared secret for generating access tokens
 	turnUsername      = getEnv("TURN_USERNAME", "")       // Cloudflare TURN username
 	turnCredential    = getEnv("TURN_CREDENTIAL", "")     // Cloudflare TURN password
-	youtubeRTMPURL    = getEnv("YOUTUBE_RTMP_URL", "rtmp://a.rtmp.youtube.com/live2")
-	youtubeStreamKey  = os.Getenv("YOUTUBE_STREAM_KEY")
 	controlSecret     = getEnv("CONTROL_SECRET", "changeme")
+	mediaMTXWhipURL   = getEnv("MEDIAMTX_WHIP_URL", "http://127.0.0.1:8889") // MediaMTX's own WHIP listener, used to bridge WHIP car ingest
+	recordDir         = getEnv("RECORD_DIR", "")                            // optional: save segmented local MP4s here alongside the push
+	recordMaxBytes    = getEnvInt64("RECORD_MAX_BYTES", 0)                  // 0 disables the recording janitor
+
+	// tokenMgr mints/verifies the expiry+HMAC tokens used on the telemetry
+	// reconnect path and on inbound control requests. TOKEN_LEGACY_FORMAT
+	// mints generate-token.js's truncated-to-16-hex signature instead of the
+	// full HMAC; Verify accepts either regardless, so flipping the flag only
+	// changes what we mint, never what we accept.
+	tokenMgr = authtoken.NewManager(tokenSecret, getEnvBool("TOKEN_LEGACY_FORMAT", false))
+
+	// telemetryRefresher caches the token handed to TELEMETRY_OFFER_URL so the
+	// reconnect loop reuses one token across attempts instead of minting a
+	// fresh 60-minute token every time.
+	telemetryRefresher = authtoken.NewRefresher(tokenMgr, 60*time.Minute, 5*time.Minute)
+
+	// authTokenTTL is how long tokens minted by POST /auth/token for browser
+	// clients stay valid.
+	authTokenTTL = time.Duration(getEnvInt64("AUTH_TOKEN_TTL_MINUTES", 15)) * time.Minute
 
 	// Telemetry state
 	telemetryMu      sync.RWMutex
 	currentTelemetry Telemetry
 	telemetryPC      *webrtc.PeerConnection
+	telemetryHub     = newTelemetryHub()
+
+	// WHIP ingest sessions, keyed by resource ID
+	whipMu       sync.Mutex
+	whipSessions = map[string]*webrtc.PeerConnection{}
 )
 
 // Telemetry data received from Pi
@@ -61,20 +85,60 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
-// generateToken creates an HMAC-SHA256 signed token (same format as generate-token.js)
-func generateToken(durationMinutes int) string {
-	if tokenSecret == "" {
-		return ""
+func getEnvInt64(key string, fallback int64) int64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return fallback
 	}
+	return n
+}
 
-	expiryTime := time.Now().Unix() + int64(durationMinutes*60)
-	expiryHex := fmt.Sprintf("%08x", expiryTime)
+func getEnvBool(key string, fallback bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fallback
+	}
+	return b
+}
 
-	h := hmac.New(sha256.New, []byte(tokenSecret))
-	h.Write([]byte(expiryHex))
-	signature := hex.EncodeToString(h.Sum(nil))[:16] // First 16 chars
+// loadDestinations parses the DESTINATIONS env var, a JSON array like
+// `[{"name":"youtube","url":"rtmp://..."},{"name":"archive","url":"srt://..."}]`.
+func loadDestinations() ([]broadcast.Destination, error) {
+	raw := getEnv("DESTINATIONS", "")
+	if raw == "" {
+		return nil, nil
+	}
+	var dests []broadcast.Destination
+	if err := json.Unmarshal([]byte(raw), &dests); err != nil {
+		return nil, fmt.Errorf("invalid DESTINATIONS: %w", err)
+	}
+	for _, d := range dests {
+		if d.Name == "" || d.URL == "" {
+			return nil, fmt.Errorf("invalid DESTINATIONS: each entry needs a name and url")
+		}
+	}
+	return dests, nil
+}
 
-	return expiryHex + signature
+// broadcastConfig builds the broadcast.Config from current env-derived
+// settings and the given destination list.
+func broadcastConfig(dests []broadcast.Destination) broadcast.Config {
+	return broadcast.Config{
+		CarPath:          "car",
+		CamWhepURL:       camWhepURL,
+		TelemetryOverlay: telemetryOfferURL != "",
+		Destinations:     dests,
+		RecordDir:        recordDir,
+		RecordMaxBytes:   recordMaxBytes,
+	}
 }
 
 // ----- Telemetry Client -----
@@ -139,6 +203,13 @@ func parseTelemetryMessage(data []byte) {
 	if err := updateTelemetryFile(); err != nil {
 		log.Printf("Error updating telemetry file: %v", err)
 	}
+
+	telemetryHub.publish(telemetryFrame{
+		RaceTimeMs:  int32(raceTime),
+		ThrottlePct: int(float64(throttle) / 32767 * 100),
+		SteeringPct: int(float64(steering) / 32767 * 100),
+		Ts:          time.Now().UnixMilli(),
+	})
 }
 
 func startTelemetryClient() error {
@@ -149,30 +220,14 @@ func startTelemetryClient() error {
 
 	log.Printf("Starting telemetry client, connecting to %s", telemetryOfferURL)
 
-	// Create peer connection with TURN servers for NAT traversal
-	iceServers := []webrtc.ICEServer{
-		{URLs: []string{"stun:stun.cloudflare.com:3478"}},
-	}
-
-	// Add Cloudflare TURN if credentials available
 	if turnUsername != "" && turnCredential != "" {
-		iceServers = append(iceServers, webrtc.ICEServer{
-			URLs:       []string{"turn:turn.cloudflare.com:3478?transport=udp"},
-			Username:   turnUsername,
-			Credential: turnCredential,
-		})
-		iceServers = append(iceServers, webrtc.ICEServer{
-			URLs:       []string{"turn:turn.cloudflare.com:3478?transport=tcp"},
-			Username:   turnUsername,
-			Credential: turnCredential,
-		})
 		log.Println("Using Cloudflare TURN for telemetry connection")
 	} else {
 		log.Println("WARNING: No TURN credentials, telemetry may fail behind NAT")
 	}
 
 	config := webrtc.Configuration{
-		ICEServers: iceServers,
+		ICEServers: buildICEServers(),
 	}
 
 	pc, err := webrtc.NewPeerConnection(config)
@@ -232,12 +287,13 @@ func startTelemetryClient() error {
 	gatherComplete := webrtc.GatheringCompletePromise(pc)
 	<-gatherComplete
 
-	// Send offer to Pi's control endpoint with generated token
+	// Send offer to Pi's control endpoint, reusing the refresher's cached
+	// token instead of minting a fresh one on every reconnect attempt.
 	offerURL := telemetryOfferURL
 	if tokenSecret != "" {
-		token := generateToken(60) // 60 minute token
+		token := telemetryRefresher.Token()
 		offerURL += "?token=" + url.QueryEscape(token)
-		log.Printf("Generated access token for control endpoint")
+		log.Printf("Using access token for control endpoint")
 	}
 
 	resp, err := http.Post(offerURL, "application/sdp", strings.NewReader(pc.LocalDescription().SDP))
@@ -278,195 +334,298 @@ func uint16Ptr(v uint16) *uint16 {
 	return &v
 }
 
-// ----- MediaMTX & FFmpeg -----
-
-func writeMediaMTXConfig() error {
-	// Strip protocol from URL - MediaMTX uses whep:// (HTTP) or wheps:// (HTTPS)
-	whepURL := camWhepURL
-	scheme := "whep"
-	if strings.HasPrefix(whepURL, "https://") {
-		scheme = "wheps"
-		whepURL = strings.TrimPrefix(whepURL, "https://")
-	} else {
-		whepURL = strings.TrimPrefix(whepURL, "http://")
+// buildICEServers returns the ICE server set shared by every peer
+// connection we create, adding Cloudflare TURN when credentials are
+// configured.
+func buildICEServers() []webrtc.ICEServer {
+	iceServers := []webrtc.ICEServer{
+		{URLs: []string{"stun:stun.cloudflare.com:3478"}},
 	}
+	if turnUsername != "" && turnCredential != "" {
+		iceServers = append(iceServers, webrtc.ICEServer{
+			URLs:       []string{"turn:turn.cloudflare.com:3478?transport=udp"},
+			Username:   turnUsername,
+			Credential: turnCredential,
+		})
+		iceServers = append(iceServers, webrtc.ICEServer{
+			URLs:       []string{"turn:turn.cloudflare.com:3478?transport=tcp"},
+			Username:   turnUsername,
+			Credential: turnCredential,
+		})
+	}
+	return iceServers
+}
 
-	config := fmt.Sprintf(`logLevel: info
-logDestinations: [stdout]
-
-api: yes
-apiAddress: 127.0.0.1:9997
-
-rtsp: yes
-rtspAddress: :8554
+// ----- WHIP ingest -----
+//
+// Accepts SDP offers pushed by the Pi (camera + telemetry) instead of the
+// restreamer pulling WHEP / POSTing to TELEMETRY_OFFER_URL. This lets the
+// service run as a pure sink in constrained-NAT setups where the Pi can
+// reach us but we can't easily reach it.
+
+// newWhipResourceID generates a random ID for the Location header of a
+// newly created WHIP resource.
+func newWhipResourceID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
 
-webrtc: yes
-webrtcAddress: :8889
+// handleWhip dispatches WHIP requests for both the "car" and "telemetry"
+// streams: POST {stream} to offer, DELETE {stream}/{id} to tear down.
+func handleWhip(w http.ResponseWriter, r *http.Request) {
+	if !checkAuth(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
 
-paths:
-  car:
-    source: %s://%s
-    sourceOnDemand: no
-`, scheme, whepURL)
+	path := strings.TrimPrefix(r.URL.Path, "/whip/")
+	parts := strings.SplitN(path, "/", 2)
+	stream := parts[0]
+	if stream != "car" && stream != "telemetry" {
+		http.Error(w, "unknown WHIP stream", http.StatusNotFound)
+		return
+	}
 
-	return os.WriteFile("/tmp/mediamtx.yml", []byte(config), 0644)
+	switch r.Method {
+	case http.MethodPost:
+		if len(parts) > 1 {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handleWhipOffer(w, r, stream)
+	case http.MethodDelete:
+		if len(parts) < 2 || parts[1] == "" {
+			http.Error(w, "missing resource id", http.StatusBadRequest)
+			return
+		}
+		handleWhipDelete(w, parts[1])
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
 }
 
-func startMediaMTX() error {
-	mu.Lock()
-	defer mu.Unlock()
+// handleWhipOffer accepts an SDP offer for stream ("car" or "telemetry"),
+// answers it, and wires the resulting PeerConnection into the existing
+// pipeline: car video is bridged into MediaMTX so the FFmpeg restream path
+// keeps working unchanged, telemetry data channel messages go through the
+// same parseTelemetryMessage used by the pull-based telemetry client.
+func handleWhipOffer(w http.ResponseWriter, r *http.Request, stream string) {
+	if ct := r.Header.Get("Content-Type"); ct != "application/sdp" {
+		http.Error(w, "Content-Type must be application/sdp", http.StatusUnsupportedMediaType)
+		return
+	}
+	offerSDP, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "failed to read offer", http.StatusBadRequest)
+		return
+	}
 
-	if mediamtxCmd != nil && mediamtxCmd.Process != nil {
-		// Already running
-		if err := mediamtxCmd.Process.Signal(syscall.Signal(0)); err == nil {
-			return nil
-		}
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{ICEServers: buildICEServers()})
+	if err != nil {
+		http.Error(w, "failed to create peer connection", http.StatusInternalServerError)
+		return
 	}
 
-	if err := writeMediaMTXConfig(); err != nil {
-		return err
+	switch stream {
+	case "telemetry":
+		pc.OnDataChannel(func(dc *webrtc.DataChannel) {
+			dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+				parseTelemetryMessage(msg.Data)
+			})
+		})
+	case "car":
+		if _, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{
+			Direction: webrtc.RTPTransceiverDirectionRecvonly,
+		}); err != nil {
+			pc.Close()
+			http.Error(w, "failed to add video transceiver", http.StatusInternalServerError)
+			return
+		}
+		pc.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+			if err := bridgeTrackToMediaMTX(stream, remote); err != nil {
+				log.Printf("WHIP %s: failed to bridge track to MediaMTX: %v", stream, err)
+			}
+		})
 	}
 
-	mediamtxCmd = exec.Command("mediamtx", "/tmp/mediamtx.yml")
-	mediamtxCmd.Stdout = os.Stdout
-	mediamtxCmd.Stderr = os.Stderr
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  string(offerSDP),
+	}); err != nil {
+		pc.Close()
+		http.Error(w, "invalid offer", http.StatusBadRequest)
+		return
+	}
 
-	if err := mediamtxCmd.Start(); err != nil {
-		return err
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		pc.Close()
+		http.Error(w, "failed to create answer", http.StatusInternalServerError)
+		return
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		pc.Close()
+		http.Error(w, "failed to set local description", http.StatusInternalServerError)
+		return
 	}
+	<-gatherComplete
 
-	log.Printf("MediaMTX started (PID: %d)", mediamtxCmd.Process.Pid)
-	return nil
-}
+	id := newWhipResourceID()
+	whipMu.Lock()
+	whipSessions[id] = pc
+	whipMu.Unlock()
 
-// waitForStream polls MediaMTX API until the stream has tracks ready
-func waitForStream(path string, timeout time.Duration) error {
-	deadline := time.Now().Add(timeout)
-	for time.Now().Before(deadline) {
-		resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:9997/v3/paths/get/%s", path))
-		if err == nil {
-			if resp.StatusCode == 200 {
-				var result map[string]interface{}
-				if json.NewDecoder(resp.Body).Decode(&result) == nil {
-					// Check if stream is ready (has tracks)
-					if ready, ok := result["ready"].(bool); ok && ready {
-						log.Printf("Stream '%s' is ready", path)
-						resp.Body.Close()
-						return nil
-					}
-					log.Printf("Waiting for stream '%s'... (ready=%v)", path, result["ready"])
-				}
-			}
-			resp.Body.Close()
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
+			whipMu.Lock()
+			delete(whipSessions, id)
+			whipMu.Unlock()
 		}
-		time.Sleep(2 * time.Second)
+	})
+
+	log.Printf("WHIP %s: session %s established", stream, id)
+
+	w.Header().Set("Location", fmt.Sprintf("/whip/%s/%s", stream, id))
+	w.Header().Set("Content-Type", "application/sdp")
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(pc.LocalDescription().SDP))
+}
+
+// closeWhipSessions tears down every open WHIP session, for process shutdown.
+func closeWhipSessions() {
+	whipMu.Lock()
+	defer whipMu.Unlock()
+	for id, pc := range whipSessions {
+		pc.Close()
+		delete(whipSessions, id)
 	}
-	return fmt.Errorf("timeout waiting for stream '%s'", path)
 }
 
-func startFFmpeg() error {
-	mu.Lock()
-	defer mu.Unlock()
+// handleWhipDelete tears down a previously created WHIP resource, per the
+// WHIP draft's DELETE-to-terminate semantics.
+func handleWhipDelete(w http.ResponseWriter, id string) {
+	whipMu.Lock()
+	pc, ok := whipSessions[id]
+	delete(whipSessions, id)
+	whipMu.Unlock()
 
-	if youtubeStreamKey == "" {
-		return fmt.Errorf("YOUTUBE_STREAM_KEY not set")
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
 	}
+	pc.Close()
+	w.WriteHeader(http.StatusOK)
+}
 
-	if ffmpegCmd != nil && ffmpegCmd.Process != nil {
-		if err := ffmpegCmd.Process.Signal(syscall.Signal(0)); err == nil {
-			return fmt.Errorf("already streaming")
-		}
+// bridgeTrackToMediaMTX republishes an inbound WHIP video track into
+// MediaMTX's own WHIP listener under the given path, so the existing
+// RTSP-based FFmpeg pipeline keeps consuming "car" unchanged.
+func bridgeTrackToMediaMTX(path string, remote *webrtc.TrackRemote) error {
+	local, err := webrtc.NewTrackLocalStaticRTP(remote.Codec().RTPCodecCapability, remote.Kind().String(), "whip-bridge")
+	if err != nil {
+		return fmt.Errorf("create local track: %w", err)
 	}
 
-	// Start MediaMTX first (unlocked call)
-	mu.Unlock()
-	if err := startMediaMTX(); err != nil {
-		mu.Lock()
-		return err
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return fmt.Errorf("create bridge peer connection: %w", err)
 	}
-
-	// Wait for stream to be ready (up to 30 seconds)
-	if err := waitForStream("car", 30*time.Second); err != nil {
-		mu.Lock()
-		return err
+	if _, err := pc.AddTrack(local); err != nil {
+		pc.Close()
+		return fmt.Errorf("add local track: %w", err)
 	}
-	mu.Lock()
 
-	rtmpURL := fmt.Sprintf("%s/%s", youtubeRTMPURL, youtubeStreamKey)
-
-	// Build FFmpeg command with optional telemetry overlay
-	args := []string{
-		"-hide_banner", "-loglevel", "info",
-		"-rtsp_transport", "tcp",
-		"-i", "rtsp://127.0.0.1:8554/car",
-		"-f", "lavfi", "-i", "anullsrc=channel_layout=stereo:sample_rate=44100", // silent audio
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		pc.Close()
+		return fmt.Errorf("create bridge offer: %w", err)
 	}
-
-	// Add telemetry overlay if control URL is configured
-	if telemetryOfferURL != "" {
-		// Use drawtext filter with reload=1 to read telemetry.txt
-		args = append(args,
-			"-vf", "drawtext=fontfile=/usr/share/fonts/dejavu/DejaVuSans-Bold.ttf:"+
-				"textfile=/tmp/telemetry.txt:reload=1:"+
-				"x=20:y=h-50:fontsize=24:fontcolor=white:"+
-				"box=1:boxcolor=black@0.6:boxborderw=8",
-		)
-	}
-
-	args = append(args,
-		"-c:v", "libx264", "-preset", "veryfast", "-tune", "zerolatency",
-		"-b:v", "2500k", "-maxrate", "3000k", "-bufsize", "6000k",
-		"-pix_fmt", "yuv420p",
-		"-g", "60", "-keyint_min", "60",
-		"-c:a", "aac", "-b:a", "128k",
-		"-shortest",
-		"-f", "flv", rtmpURL,
-	)
-
-	// YouTube requires audio, so we generate silent audio for video-only streams
-	ffmpegCmd = exec.Command("ffmpeg", args...)
-	ffmpegCmd.Stdout = os.Stdout
-	ffmpegCmd.Stderr = os.Stderr
-
-	if err := ffmpegCmd.Start(); err != nil {
-		return err
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		pc.Close()
+		return fmt.Errorf("set bridge local description: %w", err)
 	}
+	<-gatherComplete
 
-	log.Printf("FFmpeg started (PID: %d)", ffmpegCmd.Process.Pid)
-	return nil
-}
+	resp, err := http.Post(mediaMTXWhipURL+"/"+path+"/whip", "application/sdp", strings.NewReader(pc.LocalDescription().SDP))
+	if err != nil {
+		pc.Close()
+		return fmt.Errorf("publish to mediamtx: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		pc.Close()
+		return fmt.Errorf("mediamtx rejected publish: %s - %s", resp.Status, string(body))
+	}
+	answerSDP, err := io.ReadAll(resp.Body)
+	if err != nil {
+		pc.Close()
+		return fmt.Errorf("read mediamtx answer: %w", err)
+	}
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: string(answerSDP)}); err != nil {
+		pc.Close()
+		return fmt.Errorf("set bridge remote description: %w", err)
+	}
 
-func stopFFmpeg() {
-	mu.Lock()
-	defer mu.Unlock()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, _, err := remote.Read(buf)
+			if err != nil {
+				pc.Close()
+				return
+			}
+			if _, err := local.Write(buf[:n]); err != nil {
+				log.Printf("WHIP %s: bridge write to MediaMTX failed: %v", path, err)
+				pc.Close()
+				return
+			}
+		}
+	}()
 
-	if ffmpegCmd != nil && ffmpegCmd.Process != nil {
-		ffmpegCmd.Process.Kill()
-		ffmpegCmd.Wait()
-		ffmpegCmd = nil
-		log.Println("FFmpeg stopped")
-	}
+	log.Printf("WHIP %s: bridging track into MediaMTX at %s", path, mediaMTXWhipURL+"/"+path+"/whip")
+	return nil
 }
 
-func isStreaming() bool {
-	mu.Lock()
-	defer mu.Unlock()
-
-	if ffmpegCmd == nil || ffmpegCmd.Process == nil {
+// checkControlSecret reports whether r carries the long-lived CONTROL_SECRET
+// as a bearer token. POST /auth/token accepts only this; every other
+// endpoint goes through checkAuth, which accepts it too but also takes a
+// short-lived token, so the control secret never needs to reach a browser.
+func checkControlSecret(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
 		return false
 	}
-	return ffmpegCmd.Process.Signal(syscall.Signal(0)) == nil
+	secret := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(controlSecret)) == 1
 }
 
+// checkAuth reports whether r is authorized to hit a control endpoint: either
+// the long-lived CONTROL_SECRET as an "Authorization: Bearer" header, or a
+// short-lived authtoken as a "Authorization: Bearer" header or "?token="
+// query param.
 func checkAuth(r *http.Request) bool {
-	auth := r.Header.Get("Authorization")
-	return auth == "Bearer "+controlSecret
+	if checkControlSecret(r) {
+		return true
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		if tokenMgr.Verify(strings.TrimPrefix(auth, "Bearer ")) {
+			return true
+		}
+	}
+	if token := r.URL.Query().Get("token"); token != "" {
+		return tokenMgr.Verify(token)
+	}
+	return false
 }
 
 func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
 
 		if r.Method == "OPTIONS" {
@@ -487,9 +646,21 @@ func main() {
 	log.Printf("Starting restreamer...")
 	log.Printf("CAM_WHEP_URL: %s", camWhepURL)
 
+	dests, err := loadDestinations()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	log.Printf("Destinations: %v", dests)
+
 	// Initialize telemetry file with default values
 	os.WriteFile("/tmp/telemetry.txt", []byte("TIME 00:00.000  THR 0%  STR 0%"), 0644)
 
+	// Start MediaMTX up front so it's ready to accept WHIP pushes even
+	// before /start is called; mgr.Start's own MediaMTX start is then a no-op.
+	if err := mgr.EnsureMediaMTX(broadcastConfig(dests)); err != nil {
+		log.Printf("Failed to start MediaMTX: %v", err)
+	}
+
 	// Start telemetry client if configured
 	if telemetryOfferURL != "" {
 		log.Printf("TELEMETRY_OFFER_URL: %s", telemetryOfferURL)
@@ -506,21 +677,225 @@ func main() {
 		}()
 	}
 
+	http.HandleFunc("/whip/", corsMiddleware(handleWhip))
+
 	http.HandleFunc("/health", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		jsonResponse(w, map[string]string{"status": "ok"})
 	}))
 
+	http.HandleFunc("/auth/token", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !checkControlSecret(r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		token := tokenMgr.Generate(authTokenTTL)
+		if token == "" {
+			http.Error(w, "TOKEN_SECRET not configured", http.StatusServiceUnavailable)
+			return
+		}
+		jsonResponse(w, map[string]interface{}{
+			"token":      token,
+			"expires_in": int(authTokenTTL.Seconds()),
+		})
+	}))
+
 	http.HandleFunc("/status", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		telemetryMu.RLock()
 		telemetryAge := time.Since(currentTelemetry.LastUpdate).Seconds()
 		telemetryMu.RUnlock()
 
+		// /status is unauthenticated (it's polled by the public viewer page),
+		// so destination statuses must be redacted -- the raw URL and
+		// FFmpeg-stderr LastError both carry the destination's embedded
+		// stream key.
 		jsonResponse(w, map[string]interface{}{
-			"streaming":       isStreaming(),
+			"state":           mgr.State().String(),
+			"streaming":       mgr.IsStreaming(),
 			"telemetry_age_s": telemetryAge,
+			"destinations":    broadcast.RedactDestinations(mgr.DestinationStatuses()),
 		})
 	}))
 
+	http.HandleFunc("/events", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		ch, cancel := mgr.Subscribe()
+		defer cancel()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		for {
+			select {
+			case ev, ok := <-ch:
+				if !ok {
+					return
+				}
+				// /events is unauthenticated (the same public viewer page
+				// that polls /status subscribes here), so destination
+				// statuses need the same URL/LastError redaction. ev.Destinations
+				// is shared with every other subscriber's copy of this same
+				// event, so build a fresh slice rather than redacting in place.
+				ev.Destinations = broadcast.RedactDestinations(ev.Destinations)
+				data, err := json.Marshal(ev)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "data: %s\n\n", data)
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+
+	// No WebSocket library is vendored in this snapshot, so this serves
+	// frames over SSE only; EventSource speaks it natively in the browser.
+	http.HandleFunc("/telemetry/stream", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		sub := telemetryHub.subscribe()
+		defer telemetryHub.unsubscribe(sub)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		ping := time.NewTicker(15 * time.Second)
+		defer ping.Stop()
+
+		for {
+			select {
+			case <-sub.notify:
+				for _, f := range sub.drain() {
+					data, err := json.Marshal(f)
+					if err != nil {
+						continue
+					}
+					fmt.Fprintf(w, "data: %s\n\n", data)
+				}
+				flusher.Flush()
+			case <-ping.C:
+				fmt.Fprint(w, ": ping\n\n")
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+
+	http.HandleFunc("/recordings", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if !checkAuth(r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		recordings, err := mgr.ListRecordings()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			jsonResponse(w, map[string]string{"error": err.Error()})
+			return
+		}
+		jsonResponse(w, recordings)
+	}))
+
+	http.HandleFunc("/recordings/", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if !checkAuth(r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/recordings/")
+		path, err := mgr.RecordingPath(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.ServeFile(w, r, path)
+	}))
+
+	http.HandleFunc("/destinations/add", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !checkAuth(r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var d broadcast.Destination
+		if err := json.NewDecoder(r.Body).Decode(&d); err != nil || d.Name == "" || d.URL == "" {
+			http.Error(w, "name and url are required", http.StatusBadRequest)
+			return
+		}
+
+		if err := mgr.AddDestination(d); err != nil {
+			var restartErr *broadcast.RestartError
+			if errors.As(err, &restartErr) {
+				// The destination was added; it's the live restart to pick it
+				// up that failed, so the broadcast may now be down entirely.
+				// That's not the caller's bad request -- don't report it as one.
+				w.WriteHeader(http.StatusBadGateway)
+				jsonResponse(w, map[string]string{"error": err.Error()})
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			jsonResponse(w, map[string]string{"error": err.Error()})
+			return
+		}
+		jsonResponse(w, map[string]bool{"added": true})
+	}))
+
+	http.HandleFunc("/destinations/remove", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !checkAuth(r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+
+		if err := mgr.RemoveDestination(req.Name); err != nil {
+			var restartErr *broadcast.RestartError
+			if errors.As(err, &restartErr) {
+				// The destination was removed; it's the live restart that
+				// failed, which just killed the stream to everyone else --
+				// don't report that as "destination not found".
+				w.WriteHeader(http.StatusBadGateway)
+				jsonResponse(w, map[string]string{"error": err.Error()})
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+			jsonResponse(w, map[string]string{"error": err.Error()})
+			return
+		}
+		jsonResponse(w, map[string]bool{"removed": true})
+	}))
+
 	http.HandleFunc("/start", corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "POST" {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -531,7 +906,7 @@ func main() {
 			return
 		}
 
-		if err := startFFmpeg(); err != nil {
+		if err := mgr.Start(broadcastConfig(mgr.Destinations())); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			jsonResponse(w, map[string]string{"error": err.Error()})
 			return
@@ -549,19 +924,18 @@ func main() {
 			return
 		}
 
-		stopFFmpeg()
+		mgr.Stop()
 		jsonResponse(w, map[string]bool{"stopped": true})
 
 		// Exit the process so Fly.io machine stops
 		go func() {
 			time.Sleep(500 * time.Millisecond) // Allow response to be sent
 			log.Println("Shutting down after stop request...")
-			if mediamtxCmd != nil && mediamtxCmd.Process != nil {
-				mediamtxCmd.Process.Kill()
-			}
+			mgr.Close()
 			if telemetryPC != nil {
 				telemetryPC.Close()
 			}
+			closeWhipSessions()
 			os.Exit(0)
 		}()
 	}))
@@ -572,13 +946,11 @@ func main() {
 	go func() {
 		<-sigChan
 		log.Println("Shutting down...")
-		stopFFmpeg()
-		if mediamtxCmd != nil && mediamtxCmd.Process != nil {
-			mediamtxCmd.Process.Kill()
-		}
+		mgr.Close()
 		if telemetryPC != nil {
 			telemetryPC.Close()
 		}
+		closeWhipSessions()
 		os.Exit(0)
 	}()
 
@@ -0,0 +1,78 @@
+package main
+
+import "sync"
+
+// telemetryFrame is one JSON frame pushed to GET /telemetry/stream
+// subscribers, mirroring what's burned into the FFmpeg overlay.
+type telemetryFrame struct {
+	RaceTimeMs  int32 `json:"race_time_ms"`
+	ThrottlePct int   `json:"throttle_pct"`
+	SteeringPct int   `json:"steering_pct"`
+	Ts          int64 `json:"ts"`
+}
+
+// telemetryRingSize bounds how far a slow subscriber can lag: once full, the
+// oldest unread frame is dropped instead of blocking the publisher.
+const telemetryRingSize = 32
+
+// telemetrySubscriber is a per-client ring buffer so publish() never blocks
+// on a slow reader.
+type telemetrySubscriber struct {
+	mu     sync.Mutex
+	ring   []telemetryFrame
+	notify chan struct{} // signaled (non-blocking) whenever ring gains a frame
+}
+
+func newTelemetrySubscriber() *telemetrySubscriber {
+	return &telemetrySubscriber{notify: make(chan struct{}, 1)}
+}
+
+func (s *telemetrySubscriber) push(f telemetryFrame) {
+	s.mu.Lock()
+	s.ring = append(s.ring, f)
+	if len(s.ring) > telemetryRingSize {
+		s.ring = s.ring[len(s.ring)-telemetryRingSize:]
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *telemetrySubscriber) drain() []telemetryFrame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	frames := s.ring
+	s.ring = nil
+	return frames
+}
+
+// telemetryFanout fans out telemetry frames from parseTelemetryMessage to
+// every live GET /telemetry/stream connection, keyed by subscriber so
+// publish never stalls on OnMessage regardless of how slow a client reads.
+type telemetryFanout struct {
+	subs sync.Map // *telemetrySubscriber -> struct{}
+}
+
+func newTelemetryHub() *telemetryFanout {
+	return &telemetryFanout{}
+}
+
+func (h *telemetryFanout) subscribe() *telemetrySubscriber {
+	s := newTelemetrySubscriber()
+	h.subs.Store(s, struct{}{})
+	return s
+}
+
+func (h *telemetryFanout) unsubscribe(s *telemetrySubscriber) {
+	h.subs.Delete(s)
+}
+
+func (h *telemetryFanout) publish(f telemetryFrame) {
+	h.subs.Range(func(key, _ interface{}) bool {
+		key.(*telemetrySubscriber).push(f)
+		return true
+	})
+}